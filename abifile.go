@@ -0,0 +1,144 @@
+package contractevent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// normalizeABI 从不同格式的文件中提取ABI数组：
+//  1. 原始ABI：[...]
+//  2. Hardhat/Truffle/Foundry的编译产物：{"abi":[...]}
+//  3. Etherscan接口的返回：getsourcecode为{"result":[{"ABI":"[...]"}]}，getabi为{"result":"[...]"}
+//  4. Sourcify的metadata.json：{"output":{"abi":[...]}}
+func normalizeABI(data []byte) ([]json.RawMessage, error) {
+	data = bytes.TrimSpace(data)
+	var out []json.RawMessage
+	if bytes.HasPrefix(data, []byte("[")) {
+		err := json.Unmarshal(data, &out)
+		return out, err
+	}
+	var doc struct {
+		ABI    json.RawMessage `json:"abi"`
+		Result json.RawMessage `json:"result"`
+		Output struct {
+			ABI json.RawMessage `json:"abi"`
+		} `json:"output"`
+	}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(doc.ABI) > 0:
+		return normalizeABI(doc.ABI)
+	case len(doc.Output.ABI) > 0:
+		return normalizeABI(doc.Output.ABI)
+	case len(doc.Result) > 0:
+		return etherscanABI(doc.Result)
+	}
+	return nil, fmt.Errorf("unknown abi format")
+}
+
+// etherscanABI Etherscan返回的ABI是JSON字符串，合约未验证时为错误信息
+func etherscanABI(result json.RawMessage) ([]json.RawMessage, error) {
+	var sources []struct {
+		ABI string `json:"ABI"`
+	}
+	if json.Unmarshal(result, &sources) == nil && len(sources) > 0 {
+		return normalizeABI([]byte(sources[0].ABI))
+	}
+	var abiStr string
+	err := json.Unmarshal(result, &abiStr)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(strings.TrimSpace(abiStr), "[") {
+		return nil, fmt.Errorf("etherscan error:%s", abiStr)
+	}
+	return normalizeABI([]byte(abiStr))
+}
+
+// readABIFile 读取ABI文件，文件不存在时使用内置的ABI（erc20/erc721/erc1155）
+func readABIFile(file string) ([]json.RawMessage, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		data = GetABIData(file)
+		if len(data) == 0 {
+			return nil, err
+		}
+	}
+	out, err := normalizeABI(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", file, err)
+	}
+	return out, nil
+}
+
+// mergeABIFiles 合并多个ABI文件，相同签名的事件、函数只保留第一个
+func mergeABIFiles(files ...string) ([]byte, error) {
+	return mergeABI(files, nil)
+}
+
+// mergeABI 合并ABI文件和可读的事件签名
+func mergeABI(files, signatures []string) ([]byte, error) {
+	var list [][]json.RawMessage
+	for _, file := range files {
+		items, err := readABIFile(file)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, items)
+	}
+	for _, sig := range signatures {
+		item, err := parseEventSignature(sig)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, []json.RawMessage{item})
+	}
+	var out []json.RawMessage
+	seen := make(map[string]bool)
+	for _, items := range list {
+		for _, it := range items {
+			key := abiEntryKey(it)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, it)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// abiEntryKey 事件、函数的规范签名，如event:Transfer(address,address,uint256)，
+// 忽略参数名称和indexed，相同topic0的事件只保留第一个
+func abiEntryKey(entry json.RawMessage) string {
+	var item struct {
+		Type   string     `json:"type"`
+		Name   string     `json:"name"`
+		Inputs []abiParam `json:"inputs"`
+	}
+	if json.Unmarshal(entry, &item) != nil {
+		return string(entry)
+	}
+	if item.Type == "" {
+		item.Type = "function"
+	}
+	return item.Type + ":" + item.Name + canonicalTypes(item.Inputs)
+}
+
+// canonicalTypes 参数的规范类型列表，tuple展开为(...)
+func canonicalTypes(params []abiParam) string {
+	types := make([]string, len(params))
+	for i, it := range params {
+		types[i] = it.Type
+		if strings.HasPrefix(it.Type, "tuple") {
+			types[i] = canonicalTypes(it.Components) + strings.TrimPrefix(it.Type, "tuple")
+		}
+	}
+	return "(" + strings.Join(types, ",") + ")"
+}
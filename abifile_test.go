@@ -0,0 +1,62 @@
+package contractevent
+
+import (
+	"testing"
+)
+
+func TestABIFormats(t *testing.T) {
+	cases := map[string]string{
+		"testdata/abi/hardhat.json":   "Transfer",
+		"testdata/abi/foundry.json":   "Approval",
+		"testdata/abi/etherscan.json": "Swap",
+		"testdata/abi/sourcify.json":  "Approval",
+		ABIERC20:                      "Transfer",
+	}
+	for file, name := range cases {
+		data, err := mergeABIFiles(file)
+		if err != nil {
+			t.Fatal("fail to load abi:", file, err)
+		}
+		cAbi, err := parseABI(data)
+		if err != nil {
+			t.Fatal("fail to parse abi:", file, err)
+		}
+		if _, ok := cAbi.Events[name]; !ok {
+			t.Fatal("not found event:", file, name)
+		}
+	}
+
+	data, err := mergeABIFiles("testdata/abi/hardhat.json", "testdata/abi/foundry.json", "testdata/abi/etherscan.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cAbi, err := parseABI(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Transfer在两个文件中都有，只保留一个
+	if _, ok := cAbi.Events["Transfer0"]; ok || len(cAbi.Events) != 6 {
+		t.Fatal("error merged events:", len(cAbi.Events))
+	}
+	if !cAbi.Events["Swap"].Inputs[0].Indexed {
+		t.Fatal("error indexed of Swap")
+	}
+
+	// indexed不同的相同事件只保留第一个
+	data, err = mergeABI([]string{ABIERC20}, []string{"event Transfer(address from, address to, uint256 value)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cAbi, err = parseABI(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cAbi.Events["Transfer0"]; ok || !cAbi.Events["Transfer"].Inputs[0].Indexed {
+		t.Fatal("error merged Transfer:", cAbi.Events["Transfer"])
+	}
+
+	_, err = normalizeABI([]byte(`{"status":"0","message":"NOTOK","result":"Contract source code not verified"}`))
+	if err == nil {
+		t.Fatal("hope etherscan error")
+	}
+}
@@ -15,57 +15,122 @@ type Manager struct {
 	db           *gorm.DB
 	events       map[string]*Event
 	notification map[string]*NotifyTask
-	chain        *chain
+	chains       map[string]*chain
 	router       *gin.Engine
 	wg           sync.WaitGroup
 	stopping     chan int
 }
 
 func NewManager(conf Config) (*Manager, error) {
+	return NewManagerWithSources(conf, nil)
+}
+
+// NewManagerWithSources 使用指定的数据来源代替配置中的节点，key为链的名字，默认链为空字符串
+func NewManagerWithSources(conf Config, sources map[string]LogSource) (*Manager, error) {
 	var out Manager
 	out.conf = conf
 	out.stopping = make(chan int)
-	chain, err := newChain(conf.Chain.RPCNode, conf.Chain.DelayBlock)
-	if err != nil {
-		return nil, err
+	out.chains = make(map[string]*chain)
+	chains := make(map[string]ChainConfig)
+	// 兼容单链配置，Chain作为默认链，名字为空
+	if conf.Chain.RPCNode != "" || len(conf.Chain.RPCNodes) > 0 || sources[""] != nil {
+		chains[""] = conf.Chain
+	}
+	for name, it := range conf.Chains {
+		chains[name] = it
+	}
+	for name := range sources {
+		if _, ok := chains[name]; !ok {
+			chains[name] = ChainConfig{}
+		}
+	}
+	for name, it := range chains {
+		var list []LogSource
+		if source, ok := sources[name]; ok {
+			list = append(list, source)
+		}
+		c, err := newChain(name, it, list...)
+		if err != nil {
+			return nil, err
+		}
+		out.chains[name] = c
 	}
-	out.chain = chain
 	db, err := NewDB(conf.DB)
 	if err != nil {
 		return nil, err
 	}
 	CreateBlockRecord(db)
 	CreateNotifyRecord(db)
+	CreateContractRecord(db)
+	CreateTokenRecord(db)
 	out.db = db
 	out.events = make(map[string]*Event)
 	out.notification = make(map[string]*NotifyTask)
 	for _, it := range conf.Subs {
-		if _, ok := out.events[it.Alias]; ok {
-			log.Error("exist alias:", it.Alias)
-			return nil, fmt.Errorf("exist alias:%s", it.Alias)
+		key := it.Key()
+		if _, ok := out.events[key]; ok {
+			log.Error("exist alias:", it.Chain, it.Alias)
+			return nil, fmt.Errorf("exist alias:%s", key)
+		}
+		c, ok := out.chains[it.Chain]
+		if !ok {
+			log.Error("unknown chain:", it.Chain, it.Alias)
+			return nil, fmt.Errorf("unknown chain:%s", it.Chain)
 		}
-		event, err := NewEventWithDB(it, chain.client, db)
+		event, err := NewEventWithDB(it, c, db)
 		if err != nil {
-			log.Error("fail to new event:", it.Alias, err)
+			log.Error("fail to new event:", key, err)
 			return nil, err
 		}
-		SetBlockRecord(db, it.Alias, it.StartBlock)
-		out.events[it.Alias] = event
+		SetBlockRecord(db, key, it.StartBlock)
+		out.events[key] = event
 		if it.WebHook != "" {
-			out.notification[it.Alias] = NewNotifyTask(db, it.Alias, it.WebHook)
+			out.notification[key] = NewNotifyTask(db, key, it.WebHook)
 		}
 	}
 
+	for key, it := range out.events {
+		if it.conf.Factory == nil {
+			continue
+		}
+		err = out.setupFactory(key, it)
+		if err != nil {
+			return nil, err
+		}
+	}
+	out.shareFetch()
+
 	if conf.Http.Port > 0 {
 		eng := gin.Default()
 		group := eng.Group(conf.Http.PrefixPath)
-		HttpRouter(group, db)
+		out.httpRouter(group)
 		out.router = eng
 	}
 
 	return &out, nil
 }
 
+// shareFetch 同一条链上有多个轮询的订阅时，合并它们的日志查询
+func (m *Manager) shareFetch() {
+	events := make(map[string][]*Event)
+	for _, it := range m.events {
+		if it.conf.Mode == ModeStream || it.conf.Ingest == IngestReceipts || it.native != nil {
+			continue
+		}
+		events[it.conf.Chain] = append(events[it.conf.Chain], it)
+	}
+	for name, list := range events {
+		if len(list) < 2 {
+			continue
+		}
+		c := m.chains[name]
+		fetcher := newLogFetcher(c, c.conf.FetchWait)
+		for _, it := range list {
+			fetcher.add(it)
+		}
+	}
+}
+
 func (m *Manager) Run() {
 	if m.conf.Http.Port > 0 {
 		go func() {
@@ -75,47 +140,14 @@ func (m *Manager) Run() {
 			}
 		}()
 	}
-	for alias, it := range m.events {
+	for key, it := range m.events {
 		m.wg.Add(1)
-		go func(alias string, event *Event, c *chain) {
-			var wTime time.Duration = 100
-			if event.conf.WaitPerReq == 0 {
-				event.conf.WaitPerReq = 100
-			}
-			step := event.conf.BlocksPerReq
-			for {
-				select {
-				case <-time.After(time.Millisecond * wTime):
-					wTime = time.Duration(event.conf.WaitPerReq)
-				case <-m.stopping:
-					m.wg.Done()
-					return
-				}
-				last := c.SafeBlockNumber()
-				bn, err := GetBlockRecord(m.db, alias)
-				if err != nil {
-					log.Error("fail to get block record:", alias, err)
-					wTime = 10000
-					continue
-				}
-				if bn >= last {
-					wTime = 2000
-					continue
-				}
-				bn++
-				if last > bn+step {
-					last = bn + step
-				}
-				err = event.Run(bn, last)
-				if err != nil {
-					log.Error("fail to event.Run:", alias, bn, err)
-					wTime = 5000
-					continue
-				}
-				SetBlockRecord(m.db, alias, last)
-				log.Infoln("finish block:", alias, bn, last)
-			}
-		}(alias, it, m.chain)
+		c := m.chains[it.conf.Chain]
+		if it.conf.Mode == ModeStream {
+			go m.runStream(key, it, c)
+			continue
+		}
+		go m.runPoll(key, it, c)
 	}
 
 	for alias, it := range m.notification {
@@ -140,6 +172,85 @@ func (m *Manager) Run() {
 	m.wg.Wait()
 }
 
+func (m *Manager) runPoll(alias string, event *Event, c *chain) {
+	defer m.wg.Done()
+	var wTime time.Duration = 100
+	if event.conf.WaitPerReq == 0 {
+		event.conf.WaitPerReq = 100
+	}
+	if m.backfill(alias, event, c) == errStopped {
+		return
+	}
+	learned, _ := GetBlockStep(m.db, alias)
+	step := newBlockStep(event.conf.BlocksPerReq, learned)
+	for {
+		select {
+		case <-time.After(time.Millisecond * wTime):
+			wTime = time.Duration(event.conf.WaitPerReq)
+		case <-m.stopping:
+			return
+		}
+		if !m.catchUp(alias, event, step) {
+			wTime = 5000
+			continue
+		}
+		last := c.SafeBlockNumber()
+		bn, hash, err := GetBlockRecordWithHash(m.db, alias)
+		if err != nil {
+			log.Error("fail to get block record:", alias, err)
+			wTime = 10000
+			continue
+		}
+		reorg, err := m.checkReorg(alias, event, c, bn, hash)
+		if err != nil {
+			wTime = 5000
+			continue
+		}
+		if reorg {
+			continue
+		}
+		if bn >= last {
+			wTime = 2000
+			continue
+		}
+		bn++
+		if last > step.Last(bn) {
+			last = step.Last(bn)
+		}
+		last, n, err := event.run(bn, last)
+		if err != nil {
+			log.Error("fail to event.Run:", alias, bn, err)
+			wTime = 5000
+			if isRangeError(err) && step.Shrink() {
+				log.Warnln("shrink block range:", alias, step.size)
+				SetBlockStep(m.db, alias, step.size)
+				wTime = 100
+			}
+			continue
+		}
+		if step.Grow(n) {
+			log.Infoln("grow block range:", alias, step.size)
+			SetBlockStep(m.db, alias, step.size)
+		}
+		// 没有hash时无法检查链重组，获取失败时重试，不能记录空的hash
+		lastHash, err := c.BlockHash(last)
+		for err != nil {
+			log.Warnln("fail to get block hash:", alias, last, err)
+			select {
+			case <-time.After(time.Second):
+			case <-m.stopping:
+				return
+			}
+			lastHash, err = c.BlockHash(last)
+		}
+		err = SetBlockRecordWithHash(m.db, alias, last, lastHash.Hex())
+		if err != nil {
+			log.Error("fail to set block record:", alias, last, err)
+		}
+		log.Infoln("finish block:", alias, bn, last)
+	}
+}
+
 func (m *Manager) Close() {
 	for i := 0; i < len(m.events)+len(m.notification); i++ {
 		m.stopping <- 1
@@ -0,0 +1,160 @@
+package contractevent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// backfillChunk 并行获取日志的区块区间，done关闭后logs可用
+type backfillChunk struct {
+	from, to uint64
+	logs     []types.Log
+	done     chan struct{}
+}
+
+// backfill 将BlockRecord到当前已确认区块之间的历史区块切分为多个区间，由多个worker并行获取日志，
+// 再按区块顺序处理，每处理完一个区间就推进BlockRecord，保证事件按区块顺序输出，重启后从BlockRecord继续
+func (m *Manager) backfill(alias string, event *Event, c *chain) error {
+	workers := event.conf.BackfillWorkers
+	if workers < 2 || event.native != nil {
+		return nil
+	}
+	size := event.conf.BlocksPerReq + 1
+	head := c.SafeBlockNumber()
+	cursor, err := GetBlockRecord(m.db, alias)
+	if err != nil {
+		log.Error("fail to get block record:", alias, err)
+		return err
+	}
+	if head <= cursor+size*uint64(workers) {
+		return nil
+	}
+	log.Infoln("start backfill:", alias, cursor+1, head, workers)
+
+	// ordered限制已获取但未处理的区间数量
+	ordered := make(chan *backfillChunk, workers*2)
+	chunks := make(chan *backfillChunk)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ordered)
+		defer close(chunks)
+		for from := cursor + 1; from <= head; from += size {
+			to := from + size - 1
+			if to > head {
+				to = head
+			}
+			it := &backfillChunk{from: from, to: to, done: make(chan struct{})}
+			select {
+			case ordered <- it:
+			case <-stop:
+				return
+			}
+			select {
+			case chunks <- it:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range chunks {
+				if !m.fetchChunk(alias, event, it, stop) {
+					return
+				}
+				close(it.done)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(stop)
+
+	for it := range ordered {
+		select {
+		case <-it.done:
+		case <-m.stopping:
+			return errStopped
+		}
+		for {
+			err := m.commitChunk(alias, event, c, it)
+			if err == nil {
+				break
+			}
+			if err == errStopped {
+				return err
+			}
+			select {
+			case <-time.After(5 * time.Second):
+			case <-m.stopping:
+				return errStopped
+			}
+		}
+		log.Infoln("finish backfill block:", alias, it.from, it.to)
+	}
+	log.Infoln("finish backfill:", alias, head)
+	return nil
+}
+
+// fetchChunk 获取区间的日志，节点限制区间大小时拆分为更小的区间，失败后重试，停止时返回false
+func (m *Manager) fetchChunk(alias string, event *Event, it *backfillChunk, stop chan struct{}) bool {
+	step := newBlockStep(it.to-it.from, 0)
+	for start := it.from; start <= it.to; {
+		last := step.Last(start)
+		if last > it.to {
+			last = it.to
+		}
+		logs, err := event.fetchLogs(start, last)
+		if err == nil {
+			it.logs = append(it.logs, logs...)
+			step.Grow(len(logs))
+			start = last + 1
+			continue
+		}
+		if isRangeError(err) && step.Shrink() {
+			log.Warnln("shrink backfill range:", alias, start, step.size)
+			continue
+		}
+		log.Warnln("fail to backfill:", alias, start, last, err)
+		select {
+		case <-time.After(5 * time.Second):
+		case <-stop:
+			return false
+		}
+	}
+	return true
+}
+
+// commitChunk 处理区间的日志并记录区间最后一个区块的hash
+func (m *Manager) commitChunk(alias string, event *Event, c *chain, it *backfillChunk) error {
+	hash, err := c.BlockHash(it.to)
+	if err != nil {
+		log.Warnln("fail to get block hash:", alias, it.to, err)
+		return err
+	}
+	err = event.handle(it.logs)
+	if err != nil {
+		log.Warnln("fail to process backfill logs:", alias, it.from, it.to, err)
+		return err
+	}
+	// 已经处理的日志不会再重复处理，这里失败时不能重试整个区间
+	for {
+		err = SetBlockRecordWithHash(m.db, alias, it.to, hash.Hex())
+		if err == nil {
+			return nil
+		}
+		log.Errorln("fail to set block record:", alias, it.to, err)
+		select {
+		case <-time.After(time.Second):
+		case <-m.stopping:
+			return errStopped
+		}
+	}
+}
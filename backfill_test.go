@@ -0,0 +1,120 @@
+package contractevent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// slowSource 区块越小的区间返回越慢，使后面的区间先获取完成
+type slowSource struct {
+	*MemorySource
+	mu  sync.Mutex
+	err error
+}
+
+func (s *slowSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	s.mu.Lock()
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if q.FromBlock != nil && q.FromBlock.Uint64() < 100 {
+		time.Sleep(time.Duration(100-q.FromBlock.Uint64()) * time.Millisecond / 10)
+	}
+	return s.MemorySource.FilterLogs(ctx, q)
+}
+
+func TestBackfill(t *testing.T) {
+	fixture := loadTestSource(t)
+	template, _ := fixture.FilterLogs(context.Background(), ethereum.FilterQuery{})
+	source := NewMemorySource(1)
+	addBlocks := func(from, to uint64) {
+		for n := from; n <= to; n++ {
+			source.AddBlock(n, 1700000000+n)
+			vLog := template[0]
+			vLog.BlockNumber = n
+			vLog.BlockHash = common.Hash{}
+			vLog.TxHash = common.BigToHash(newBig(n))
+			source.AddLogs(vLog)
+		}
+	}
+	addBlocks(1, 40)
+	slow := &slowSource{MemorySource: source}
+	conf := Config{
+		DB: DBConf{Engine: "sqlite", DSN: filepath.Join(t.TempDir(), "backfill.db")},
+		Subs: []SubscriptionConf{{Alias: "token", ABIFile: ABIERC20, EventName: "Transfer",
+			BlocksPerReq: 1, BackfillWorkers: 4}},
+	}
+	m, err := NewManagerWithSources(conf, map[string]LogSource{"": slow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := conf.Subs[0].Key()
+	event := m.events[key]
+	c := m.chains[""]
+	var blocks []uint64
+	event.hooks = append(event.hooks, func(alias string, info map[string]interface{}) error {
+		blocks = append(blocks, info[KBlockNumber].(uint64))
+		return nil
+	})
+	check := func(hope uint64) {
+		for i, it := range blocks {
+			if it != uint64(i+1) {
+				t.Fatal("error block order:", blocks)
+			}
+		}
+		if len(blocks) != int(hope) {
+			t.Fatal("error events:", len(blocks), hope)
+		}
+		bn, hash, _ := GetBlockRecordWithHash(m.db, key)
+		header, _ := source.HeaderByNumber(context.Background(), newBig(hope))
+		if bn != hope || hash != header.Hash().Hex() {
+			t.Fatal("error block record:", bn, hash)
+		}
+	}
+	err = m.backfill(key, event, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(40)
+
+	// 重启后从BlockRecord继续，不重复处理
+	addBlocks(41, 60)
+	c.lastSync = time.Time{}
+	err = m.backfill(key, event, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(60)
+
+	// 节点一直失败时可以停止，BlockRecord不变
+	addBlocks(61, 80)
+	c.lastSync = time.Time{}
+	slow.mu.Lock()
+	slow.err = errors.New("connection reset by peer")
+	slow.mu.Unlock()
+	done := make(chan error)
+	go func() {
+		done <- m.backfill(key, event, c)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	m.stopping <- 1
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("backfill not stopped")
+	}
+	if err != errStopped {
+		t.Fatal("hope stopped:", err)
+	}
+	check(60)
+}
@@ -2,37 +2,101 @@ package contractevent
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	log "github.com/sirupsen/logrus"
 )
 
 type chain struct {
-	client      *ethclient.Client
+	name        string
+	conf        ChainConfig
+	chainID     uint64
+	nodes       []*endpoint
+	headers     *headerCache
+	subscriber  LogSource
 	lastBlock   uint64
+	finalBlock  uint64
+	tagFailed   bool
 	lastSync    time.Time
+	lastReport  time.Time
 	delayNumber uint64
 	mu          sync.Mutex
 }
 
-func newChain(url string, delay uint64) (*chain, error) {
+// newChain 连接配置中的节点，如果指定了sources，则直接使用sources作为节点
+func newChain(name string, conf ChainConfig, sources ...LogSource) (*chain, error) {
+	switch conf.Finality {
+	case "", FinalityLatest, FinalitySafe, FinalityFinalized:
+	default:
+		return nil, fmt.Errorf("unknown finality of chain:%s %s", name, conf.Finality)
+	}
 	var out chain
-	client, err := ethclient.Dial(url)
-	if err != nil {
-		log.Errorln("fail to dial eth node:", url, err)
-		return nil, err
+	out.name = name
+	out.conf = conf
+	out.headers = newHeaderCache(&out, conf.HeaderCache)
+	for i, it := range sources {
+		out.nodes = append(out.nodes, newSourceEndpoint(fmt.Sprintf("source://%s/%d", name, i), it, conf))
+	}
+	if len(sources) > 0 {
+		out.subscriber = sources[0]
+	}
+	var urls []string
+	if conf.RPCNode != "" {
+		urls = append(urls, conf.RPCNode)
+	}
+	urls = append(urls, conf.RPCNodes...)
+	if len(sources) > 0 {
+		urls = nil
+	}
+	for _, u := range urls {
+		node, err := newEndpoint(u, conf)
+		if err != nil {
+			// 单个节点不可用时使用其他节点
+			log.Errorln("fail to dial eth node, skip it:", maskURL(u), err)
+			continue
+		}
+		out.nodes = append(out.nodes, node)
+		if out.subscriber == nil && conf.WSNode == "" && strings.HasPrefix(u, "ws") {
+			out.subscriber = node.source
+		}
+	}
+	if len(out.nodes) == 0 {
+		return nil, fmt.Errorf("not found rpc node of chain:%s", name)
 	}
-	out.client = client
-	out.lastBlock, err = client.BlockNumber(context.Background())
+	out.refreshHeads()
+	out.refreshFinality()
+	if out.lastBlock == 0 {
+		log.Errorln("fail to get block number:", name)
+		return nil, fmt.Errorf("fail to get block number of chain:%s", name)
+	}
+	id, err := out.ChainID(context.Background())
 	if err != nil {
-		log.Errorln("fail to get block number:", err)
+		log.Errorln("fail to get chain id:", name, err)
 		return nil, err
 	}
-	log.Infoln("new block:", out.lastBlock)
+	out.chainID = id.Uint64()
+	log.Infoln("new block:", name, out.chainID, out.lastBlock)
 	out.lastSync = time.Now()
-	out.delayNumber = delay
+	out.delayNumber = conf.DelayBlock
+	if conf.WSNode != "" && len(sources) == 0 {
+		out.subscriber, err = ethclient.Dial(conf.WSNode)
+		if err != nil {
+			log.Errorln("fail to dial eth websocket node:", maskURL(conf.WSNode), err)
+			return nil, err
+		}
+	}
 	return &out, nil
 }
 
@@ -40,24 +104,350 @@ func (c *chain) SafeBlockNumber() uint64 {
 	if time.Since(c.lastSync) > 2*time.Second {
 		c.mu.Lock()
 		defer c.mu.Unlock()
-		bn, err := c.client.BlockNumber(context.Background())
+		c.refreshHeads()
+		c.refreshFinality()
 		c.lastSync = time.Now()
-		if err != nil {
-			log.Warnln("fail to get block number:", err)
-		} else {
-			c.lastBlock = bn
-			log.Infoln("new block:", c.lastBlock)
+		if time.Since(c.lastReport) > time.Minute {
+			c.lastReport = time.Now()
+			for _, it := range c.Health() {
+				log.Infof("endpoint health, chain:%s url:%s latency:%.1fms error_rate:%.3f head:%d requests:%d errors:%d",
+					c.name, it.URL, it.LatencyMS, it.ErrorRate, it.Head, it.Requests, it.Errors)
+			}
 		}
 	}
+	// 使用区块标签时只有节点不支持才退回到DelayBlock，暂时获取失败时使用上次的结果
+	if c.useTag() && !c.tagFailed {
+		return c.finalBlock
+	}
 	if c.lastBlock > c.delayNumber {
 		return c.lastBlock - c.delayNumber
 	}
 	return 0
 }
 
+func (c *chain) useTag() bool {
+	return c.conf.Finality == FinalitySafe || c.conf.Finality == FinalityFinalized
+}
+
+// refreshFinality 通过safe/finalized标签获取已确认的区块，节点不支持时退回到latest减去DelayBlock
+func (c *chain) refreshFinality() {
+	var tag rpc.BlockNumber
+	switch c.conf.Finality {
+	case FinalitySafe:
+		tag = rpc.SafeBlockNumber
+	case FinalityFinalized:
+		tag = rpc.FinalizedBlockNumber
+	default:
+		return
+	}
+	if c.tagFailed {
+		return
+	}
+	header, err := c.HeaderByNumber(context.Background(), big.NewInt(int64(tag)))
+	if err != nil {
+		if isUnsupportedTag(err) {
+			c.tagFailed = true
+			log.Warnln("node not support block tag, fallback to delay block:", c.name, c.conf.Finality, err)
+			return
+		}
+		log.Warnln("fail to get block by tag:", c.name, c.conf.Finality, err)
+		return
+	}
+	// finalized <= safe <= latest，节点返回的高度超过最新区块时忽略
+	bn := header.Number.Uint64()
+	if bn > c.lastBlock {
+		log.Warnln("block by tag is higher than latest block:", c.name, c.conf.Finality, bn, c.lastBlock)
+		return
+	}
+	if bn > c.finalBlock {
+		c.finalBlock = bn
+		log.Infoln("new finalized block:", c.name, c.conf.Finality, bn)
+	}
+}
+
+// isUnsupportedTag 节点不支持safe/finalized标签：返回空的区块，或者方法、参数错误
+func isUnsupportedTag(err error) bool {
+	if errors.Is(err, ethereum.NotFound) {
+		return true
+	}
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	// -32601: method not found, -32602: invalid params
+	switch rpcErr.ErrorCode() {
+	case -32601, -32602:
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "not supported") || strings.Contains(msg, "unsupported") ||
+		strings.Contains(msg, "invalid block")
+}
+
+// refreshHeads 并发查询所有节点的最新高度，同时更新节点的健康数据
+func (c *chain) refreshHeads() {
+	var wg sync.WaitGroup
+	for _, node := range c.nodes {
+		wg.Add(1)
+		go func(node *endpoint) {
+			defer wg.Done()
+			node.limiter.Wait(context.Background(), c.cost("eth_blockNumber"))
+			start := time.Now()
+			bn, err := node.source.BlockNumber(context.Background())
+			node.record(time.Since(start), err)
+			if err != nil {
+				log.Warnln("fail to get block number:", c.name, maskURL(node.url), err)
+				return
+			}
+			node.setHead(bn)
+		}(node)
+	}
+	wg.Wait()
+	for _, node := range c.nodes {
+		if head := node.health().Head; head > c.lastBlock {
+			c.lastBlock = head
+			log.Infoln("new block:", c.name, c.lastBlock)
+		}
+	}
+}
+
+// sortedNodes 按健康度从好到差排序
+func (c *chain) sortedNodes() []*endpoint {
+	head := c.lastBlock
+	scores := make(map[*endpoint]float64, len(c.nodes))
+	out := make([]*endpoint, len(c.nodes))
+	copy(out, c.nodes)
+	for _, node := range out {
+		scores[node] = node.score(head)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return scores[out[i]] < scores[out[j]]
+	})
+	return out
+}
+
+// call 使用最健康的节点执行请求，节点异常时自动切换到下一个节点
+func (c *chain) call(cost float64, fn func(source LogSource) error) error {
+	return c.callSynced(0, cost, fn)
+}
+
+// callSynced 只使用已经同步到minHead的节点，避免落后的节点对区间查询返回不完整的结果
+func (c *chain) callSynced(minHead uint64, cost float64, fn func(source LogSource) error) error {
+	err := errors.New("no available rpc node")
+	if minHead > 0 {
+		err = fmt.Errorf("no rpc node synced to block:%d", minHead)
+	}
+	for _, node := range c.sortedNodes() {
+		if minHead > 0 && node.health().Head < minHead {
+			continue
+		}
+		err = node.limiter.Wait(context.Background(), cost)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		err = fn(node.source)
+		node.record(time.Since(start), err)
+		if err == nil || !isEndpointError(err) {
+			return err
+		}
+		log.Warnln("rpc node failed, try next:", c.name, maskURL(node.url), err)
+	}
+	return err
+}
+
+// cost 请求消耗的令牌数，默认为1，可以按方法配置，如eth_getLogs消耗更多
+func (c *chain) cost(method string) float64 {
+	if v, ok := c.conf.MethodCost[method]; ok {
+		return v
+	}
+	return 1
+}
+
+func (c *chain) Health() []EndpointHealth {
+	var out []EndpointHealth
+	for _, node := range c.nodes {
+		out = append(out, node.health())
+	}
+	return out
+}
+
+func (c *chain) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	var out []types.Log
+	var minHead uint64
+	if q.ToBlock != nil && q.ToBlock.Sign() > 0 {
+		minHead = q.ToBlock.Uint64()
+	}
+	err := c.callSynced(minHead, c.cost("eth_getLogs"), func(source LogSource) (err error) {
+		out, err = source.FilterLogs(ctx, q)
+		return
+	})
+	return out, err
+}
+
+func (c *chain) BlockNumber(ctx context.Context) (uint64, error) {
+	var out uint64
+	err := c.call(c.cost("eth_blockNumber"), func(source LogSource) (err error) {
+		out, err = source.BlockNumber(ctx)
+		return
+	})
+	return out, err
+}
+
+func (c *chain) ChainID(ctx context.Context) (*big.Int, error) {
+	var out *big.Int
+	err := c.call(c.cost("eth_chainId"), func(source LogSource) (err error) {
+		out, err = source.ChainID(ctx)
+		return
+	})
+	return out, err
+}
+
+func (c *chain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var out *types.Header
+	err := c.call(c.cost("eth_getBlockByNumber"), func(source LogSource) (err error) {
+		out, err = source.HeaderByNumber(ctx, number)
+		return
+	})
+	return out, err
+}
+
+func (c *chain) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	var out *types.Header
+	err := c.call(c.cost("eth_getBlockByHash"), func(source LogSource) (err error) {
+		out, err = source.HeaderByHash(ctx, hash)
+		return
+	})
+	return out, err
+}
+
+func (c *chain) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	var out *types.Block
+	err := c.call(c.cost("eth_getBlockByNumber"), func(source LogSource) (err error) {
+		bs, ok := source.(blockSource)
+		if !ok {
+			return errNotSupported
+		}
+		out, err = bs.BlockByNumber(ctx, number)
+		return
+	})
+	return out, err
+}
+
+func (c *chain) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	var out []byte
+	err := c.call(c.cost("eth_getStorageAt"), func(source LogSource) (err error) {
+		sr, ok := source.(storageReader)
+		if !ok {
+			return errNotSupported
+		}
+		out, err = sr.StorageAt(ctx, account, key, blockNumber)
+		return
+	})
+	return out, err
+}
+
+func (c *chain) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	var out []byte
+	err := c.call(c.cost("eth_call"), func(source LogSource) (err error) {
+		cc, ok := source.(contractCaller)
+		if !ok {
+			return errNotSupported
+		}
+		out, err = cc.CallContract(ctx, msg, blockNumber)
+		return
+	})
+	return out, err
+}
+
+// BlockTime 同一条链上的所有订阅共享区块时间缓存
+func (c *chain) BlockTime(ctx context.Context, hash common.Hash) (uint64, error) {
+	return c.headers.BlockTime(ctx, hash)
+}
+
+func (c *chain) BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error) {
+	var out []*types.Receipt
+	err := c.call(c.cost("eth_getBlockReceipts"), func(source LogSource) (err error) {
+		rs, ok := source.(receiptSource)
+		if !ok {
+			return errNotSupported
+		}
+		out, err = rs.BlockReceipts(ctx, blockNrOrHash)
+		return
+	})
+	return out, err
+}
+
+func (c *chain) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	return c.call(c.cost(method), func(source LogSource) error {
+		client, ok := source.(rpcSource)
+		if !ok {
+			return errNotSupported
+		}
+		return client.Client().CallContext(ctx, result, method, args...)
+	})
+}
+
+func (c *chain) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	// 批量请求中的每个请求都消耗令牌
+	var cost float64
+	for _, it := range b {
+		cost += c.cost(it.Method)
+	}
+	return c.call(cost, func(source LogSource) error {
+		client, ok := source.(rpcSource)
+		if !ok {
+			return errNotSupported
+		}
+		return client.Client().BatchCallContext(ctx, b)
+	})
+}
+
+// BlockHash 获取主链上指定高度的区块hash，直接使用节点返回的hash，避免本地计算header hash与部分链不一致
+func (c *chain) BlockHash(number uint64) (common.Hash, error) {
+	var head struct {
+		Hash common.Hash `json:"hash"`
+	}
+	err := c.CallContext(context.Background(), &head, "eth_getBlockByNumber", hexutil.EncodeUint64(number), false)
+	if errors.Is(err, errNotSupported) {
+		header, err := c.HeaderByNumber(context.Background(), newBig(number))
+		if err != nil {
+			return common.Hash{}, err
+		}
+		return header.Hash(), nil
+	}
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if head.Hash == (common.Hash{}) {
+		return common.Hash{}, fmt.Errorf("not found block:%d", number)
+	}
+	return head.Hash, nil
+}
+
+func (c *chain) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	if c.subscriber == nil {
+		return nil, errors.New("subscription requires a websocket rpc node")
+	}
+	return c.subscriber.SubscribeFilterLogs(ctx, q, ch)
+}
+
 func (c *chain) Close() {
-	if c.client != nil {
-		c.client.Close()
-		c.client = nil
+	closed := make(map[LogSource]bool)
+	for _, it := range append([]LogSource{c.subscriber}, c.sources()...) {
+		if closer, ok := it.(interface{ Close() }); ok && !closed[it] {
+			closed[it] = true
+			closer.Close()
+		}
+	}
+	c.subscriber = nil
+	c.nodes = nil
+}
+
+func (c *chain) sources() []LogSource {
+	var out []LogSource
+	for _, node := range c.nodes {
+		out = append(out, node.source)
 	}
+	return out
 }
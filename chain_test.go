@@ -0,0 +1,140 @@
+package contractevent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type codeError struct {
+	code int
+	msg  string
+}
+
+func (e codeError) Error() string  { return e.msg }
+func (e codeError) ErrorCode() int { return e.code }
+
+func TestUnsupportedTag(t *testing.T) {
+	cases := []struct {
+		err  error
+		hope bool
+	}{
+		{ethereum.NotFound, true},
+		{codeError{-32602, "invalid argument 0: hex string without 0x prefix"}, true},
+		{codeError{-32000, "safe block tag not supported"}, true},
+		{codeError{-32005, "limit exceeded"}, false},
+		{codeError{-32000, "header not found"}, false},
+		{fmt.Errorf("wrap:%w", codeError{-32601, "the method does not exist"}), true},
+		{errors.New("connection reset by peer"), false},
+	}
+	for _, it := range cases {
+		if isUnsupportedTag(it.err) != it.hope {
+			t.Error("error unsupported tag:", it.err, ",hope:", it.hope)
+		}
+	}
+}
+
+func TestSafeBlockNumber(t *testing.T) {
+	c := chain{conf: ChainConfig{Finality: FinalitySafe}, lastBlock: 1000, lastSync: time.Now()}
+	// 还没有获取到safe区块时不能使用最新区块
+	if bn := c.SafeBlockNumber(); bn != 0 {
+		t.Fatal("error safe block:", bn)
+	}
+	c.finalBlock = 990
+	if bn := c.SafeBlockNumber(); bn != 990 {
+		t.Fatal("error safe block:", bn)
+	}
+	c.tagFailed = true
+	c.delayNumber = 5
+	if bn := c.SafeBlockNumber(); bn != 995 {
+		t.Fatal("error fallback block:", bn)
+	}
+	_, err := newChain("test", ChainConfig{Finality: "Finalized"})
+	if err == nil {
+		t.Fatal("hope error of unknown finality")
+	}
+}
+
+// failSource FilterLogs和HeaderByHash返回指定的错误
+type failSource struct {
+	*MemorySource
+	err   error
+	calls int
+}
+
+func (s *failSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.MemorySource.FilterLogs(ctx, q)
+}
+
+func (s *failSource) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.MemorySource.HeaderByHash(ctx, hash)
+}
+
+func TestChainFailover(t *testing.T) {
+	source := loadTestSource(t)
+	// 落后的节点只同步到区块101
+	behind := NewMemorySource(1)
+	behind.AddBlock(101, 0)
+	nodes := []*failSource{
+		{MemorySource: source, err: errors.New("connection reset by peer")},
+		{MemorySource: behind},
+		{MemorySource: source},
+	}
+	c, err := newChain("test", ChainConfig{}, nodes[0], nodes[1], nodes[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range c.nodes {
+		it.latency = 0
+	}
+	// 第一个节点失败后切换节点，跳过没有同步到查询区间的节点
+	logs, err := c.FilterLogs(context.Background(), ethereum.FilterQuery{FromBlock: newBig(100), ToBlock: newBig(102)})
+	if err != nil || len(logs) != 3 {
+		t.Fatal("error logs:", len(logs), err)
+	}
+	if nodes[0].calls != 1 || nodes[1].calls != 0 || nodes[2].calls != 1 {
+		t.Fatal("error calls:", nodes[0].calls, nodes[1].calls, nodes[2].calls)
+	}
+	// 失败的节点排在最后
+	if sorted := c.sortedNodes(); sorted[len(sorted)-1] != c.nodes[0] || c.nodes[0].health().Errors != 1 {
+		t.Fatal("error order after failure:", c.Health())
+	}
+
+	// 数据不存在不是节点的问题，不切换节点，也不惩罚节点
+	for _, it := range nodes {
+		it.err = ethereum.NotFound
+		it.calls = 0
+	}
+	_, err = c.HeaderByHash(context.Background(), common.HexToHash("0x01"))
+	if !errors.Is(err, ethereum.NotFound) {
+		t.Fatal("hope not found:", err)
+	}
+	var calls uint64
+	for i, it := range nodes {
+		calls += uint64(it.calls)
+		if i > 0 && c.nodes[i].health().Errors != 0 {
+			t.Fatal("not found should not be endpoint error:", c.Health())
+		}
+	}
+	if calls != 1 {
+		t.Fatal("hope only one call:", calls)
+	}
+	if isEndpointError(&json.SyntaxError{}) || !isEndpointError(errors.New("i/o timeout")) {
+		t.Fatal("error endpoint error")
+	}
+}
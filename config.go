@@ -1,17 +1,106 @@
 package contractevent
 
-import _ "embed"
+import (
+	_ "embed"
+	"slices"
+)
 
 type SubscriptionConf struct {
-	Alias        string            `yaml:"alias"`
-	Contract     []string          `yaml:"contract"`
-	ABIFile      string            `yaml:"abi_file"`
-	EventName    string            `yaml:"event_name"`
-	Filter       map[string]string `yaml:"filter"`
-	StartBlock   uint64            `yaml:"start_block"`
-	BlocksPerReq uint64            `yaml:"blocks_per_req"`
-	WaitPerReq   int64             `yaml:"wait_per_req"`
-	WebHook      string            `yaml:"web_hook"`
+	Alias           string            `yaml:"alias"`
+	Contract        []string          `yaml:"contract"`
+	ABIFile         string            `yaml:"abi_file"`
+	EventName       string            `yaml:"event_name"`
+	Filter          map[string]string `yaml:"filter"`
+	StartBlock      uint64            `yaml:"start_block"`
+	BlocksPerReq    uint64            `yaml:"blocks_per_req"`
+	WaitPerReq      int64             `yaml:"wait_per_req"`
+	WebHook         string            `yaml:"web_hook"`
+	Mode            string            `yaml:"mode,omitempty"`
+	Chain           string            `yaml:"chain,omitempty"`
+	Enrich          []string          `yaml:"enrich,omitempty"`
+	BackfillWorkers int               `yaml:"backfill_workers,omitempty"`
+	Ingest          string            `yaml:"ingest,omitempty"`
+	Factory         *FactoryConf      `yaml:"factory,omitempty"`
+	Type            string            `yaml:"type,omitempty"`
+	Wallets         []string          `yaml:"wallets,omitempty"`
+	Trace           bool              `yaml:"trace,omitempty"`
+	Proxy           bool              `yaml:"proxy,omitempty"`
+	ABIDir          string            `yaml:"abi_dir,omitempty"`
+	TokenMeta       bool              `yaml:"token_meta,omitempty"`
+	AmountField     string            `yaml:"amount_field,omitempty"`
+	// EventNames 监听多个事件，与EventName合并
+	EventNames []string `yaml:"event_names,omitempty"`
+	// Filters 按事件名称配置的filter，Filter对所有事件生效
+	Filters map[string]map[string]string `yaml:"filters,omitempty"`
+	// FilterIn 参数的值为列表，匹配其中任意一个即可，对所有事件生效，与Filter同时检查
+	FilterIn map[string][]string `yaml:"filter_in,omitempty"`
+	// Where 过滤表达式，如"value > 1e24 and to != 0x0000000000000000000000000000000000000000"
+	Where string `yaml:"where,omitempty"`
+	// Transform 修改info的字段，在过滤、保存和通知之前执行
+	Transform *TransformConf `yaml:"transform,omitempty"`
+	// Script JS脚本文件，脚本中的process(info)函数可以修改、丢弃事件，或者派生多个事件
+	Script string `yaml:"script,omitempty"`
+	// ScriptTimeout 每个事件执行脚本的超时时间，毫秒，默认1000
+	ScriptTimeout int64 `yaml:"script_timeout,omitempty"`
+	// Plugin WASM插件文件，在Script之后执行
+	Plugin string `yaml:"plugin,omitempty"`
+	// PluginFunc 插件中处理事件的函数，默认为process
+	PluginFunc string `yaml:"plugin_func,omitempty"`
+	// PluginTimeout 每个事件执行插件的超时时间，毫秒，默认1000
+	PluginTimeout int64 `yaml:"plugin_timeout,omitempty"`
+	// ABIFiles 多个ABI文件，与ABIFile合并
+	ABIFiles []string `yaml:"abi_files,omitempty"`
+	// Signatures 可读的事件签名，如"event Transfer(address indexed from, address indexed to, uint256 value)"，
+	// 可以代替ABI文件，也可以与ABI文件合并
+	Signatures []string `yaml:"signatures,omitempty"`
+}
+
+// TransformConf 依次执行compute、rename、set、drop
+type TransformConf struct {
+	// Compute 计算新的字段
+	Compute []ComputeConf `yaml:"compute,omitempty"`
+	// Rename 字段改名，key为原来的名称，value为新的名称
+	Rename map[string]string `yaml:"rename,omitempty"`
+	// Set 增加固定值的字段，如tag
+	Set map[string]string `yaml:"set,omitempty"`
+	// Drop 删除字段
+	Drop []string `yaml:"drop,omitempty"`
+}
+
+// ComputeConf 使用From字段的值计算Field字段，Func为scale/lower/upper/string，
+// scale的Arg为小数位数，可以是数字或者字段名，如token_decimals
+type ComputeConf struct {
+	Field string `yaml:"field"`
+	From  string `yaml:"from"`
+	Func  string `yaml:"func"`
+	Arg   string `yaml:"arg,omitempty"`
+}
+
+// Key 订阅的唯一标识，用于BlockRecord、NotifyRecord和数据库表名，
+// 默认链直接使用Alias，其他链使用"<chain>_<alias>"
+func (c SubscriptionConf) Key() string {
+	return SubscriptionKey(c.Chain, c.Alias)
+}
+
+// Events 订阅的事件名称，为空表示所有事件
+func (c SubscriptionConf) Events() []string {
+	var out []string
+	if c.EventName != "" {
+		out = append(out, c.EventName)
+	}
+	for _, it := range c.EventNames {
+		if it != "" && !slices.Contains(out, it) {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
+func SubscriptionKey(chain, alias string) string {
+	if chain == "" {
+		return alias
+	}
+	return chain + "_" + alias
 }
 
 type DBConf struct {
@@ -21,8 +110,17 @@ type DBConf struct {
 }
 
 type ChainConfig struct {
-	RPCNode    string `yaml:"rpc_node,omitempty"`
-	DelayBlock uint64 `yaml:"delay_block,omitempty"`
+	RPCNode     string             `yaml:"rpc_node,omitempty"`
+	RPCNodes    []string           `yaml:"rpc_nodes,omitempty"`
+	WSNode      string             `yaml:"ws_node,omitempty"`
+	DelayBlock  uint64             `yaml:"delay_block,omitempty"`
+	ReorgDepth  uint64             `yaml:"reorg_depth,omitempty"`
+	Finality    string             `yaml:"finality,omitempty"`
+	HeaderCache int                `yaml:"header_cache,omitempty"`
+	FetchWait   int64              `yaml:"fetch_wait,omitempty"`
+	RateLimit   float64            `yaml:"rate_limit,omitempty"`
+	RateBurst   int                `yaml:"rate_burst,omitempty"`
+	MethodCost  map[string]float64 `yaml:"method_cost,omitempty"`
 }
 
 type ServerConfig struct {
@@ -31,12 +129,29 @@ type ServerConfig struct {
 }
 
 type Config struct {
-	Chain ChainConfig        `yaml:"chain,omitempty"`
-	DB    DBConf             `yaml:"db,omitempty"`
-	Subs  []SubscriptionConf `yaml:"subscriptions,omitempty"`
-	Http  ServerConfig       `yaml:"http,omitempty"`
+	Chain  ChainConfig            `yaml:"chain,omitempty"`
+	Chains map[string]ChainConfig `yaml:"chains,omitempty"`
+	DB     DBConf                 `yaml:"db,omitempty"`
+	Subs   []SubscriptionConf     `yaml:"subscriptions,omitempty"`
+	Http   ServerConfig           `yaml:"http,omitempty"`
 }
 
+const (
+	FinalityLatest    = "latest"
+	FinalitySafe      = "safe"
+	FinalityFinalized = "finalized"
+)
+
+const (
+	ModePoll   = "poll"
+	ModeStream = "stream"
+)
+
+const (
+	TypeEvent  = "event"
+	TypeNative = "native"
+)
+
 const (
 	ABIERC20   = "erc20"
 	ABIERC721  = "erc721"
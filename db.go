@@ -40,11 +40,14 @@ func NewDB(conf DBConf) (*gorm.DB, error) {
 	}
 }
 
+// DBItem 保存的事件，tx限制长度，mysql/sqlserver不能为text类型的字段创建索引
 type DBItem struct {
 	gorm.Model
-	TX       string `gorm:"column:tx"`
-	LogIndex uint   `gorm:"column:log_index"`
-	Others   []byte
+	TX        string `gorm:"column:tx;size:66"`
+	LogIndex  uint   `gorm:"column:log_index"`
+	SubIndex  uint   `gorm:"column:sub_index"`
+	BlockTime uint64 `gorm:"column:block_time"`
+	Others    []byte
 }
 
 func dyncTable(db *gorm.DB, alias string) *gorm.DB {
@@ -54,37 +57,83 @@ func dyncTable(db *gorm.DB, alias string) *gorm.DB {
 func CreateEventTable(db *gorm.DB, alias string) error {
 	err := dyncTable(db, alias).AutoMigrate(&DBItem{})
 	if err != nil {
-		return nil
+		return err
 	}
 	name := dyncTable(db, alias).Statement.Table
-	rst := db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXIST idx_tx_%s ON %s(tx,log_index)", name, name))
-	return rst.Error
+	// 脚本和插件可以从一个事件派生多个事件，旧的唯一索引不包含sub_index，需要替换
+	rst := db.Exec(fmt.Sprintf("UPDATE %s SET sub_index = 0 WHERE sub_index IS NULL", name))
+	if rst.Error != nil {
+		return rst.Error
+	}
+	// 使用Migrator检查和删除索引，兼容mysql/sqlserver（不支持IF EXISTS）
+	m := dyncTable(db, alias).Migrator()
+	if m.HasIndex(&DBItem{}, "idx_tx_"+name) {
+		err = m.DropIndex(&DBItem{}, "idx_tx_"+name)
+		if err != nil {
+			return err
+		}
+	}
+	indexes := []struct {
+		name string
+		sql  string
+	}{
+		{"idx_tx_sub_" + name, "CREATE UNIQUE INDEX %s ON %s(tx,log_index,sub_index)"},
+		{"idx_time_" + name, "CREATE INDEX %s ON %s(block_time)"},
+	}
+	for _, it := range indexes {
+		if m.HasIndex(&DBItem{}, it.name) {
+			continue
+		}
+		rst = db.Exec(fmt.Sprintf(it.sql, it.name, name))
+		if rst.Error != nil {
+			return rst.Error
+		}
+	}
+	return nil
 }
 
+// InsertItem 保存事件，已存在时返回原来的id。重组回滚后会重新处理之前的区块，
+// 先查询再插入，避免插入失败的重复事件占用自增id（Postgres/MySQL）
 func InsertItem(db *gorm.DB, alias string, item DBItem) (uint, error) {
+	var old DBItem
+	dyncTable(db, alias).Where("tx = ? AND log_index = ? AND sub_index = ?", item.TX, item.LogIndex, item.SubIndex).Limit(1).Find(&old)
+	if old.ID > 0 {
+		return old.ID, nil
+	}
 	rst := dyncTable(db, alias).Create(&item)
 	if rst.Error != nil {
 		var it DBItem
-		it.TX = item.TX
-		it.LogIndex = item.LogIndex
-		dyncTable(db, alias).Where(&it).First(&it)
+		dyncTable(db, alias).Where("tx = ? AND log_index = ? AND sub_index = ?", item.TX, item.LogIndex, item.SubIndex).First(&it)
 		if it.ID > 0 {
 			return it.ID, nil
 		}
-		log.Warnln("fail to insert item:", alias, it.TX, it.LogIndex, rst.Error)
+		log.Warnln("fail to insert item:", alias, item.TX, item.LogIndex, item.SubIndex, rst.Error)
 		return 0, rst.Error
 	}
 	return item.ID, nil
 }
 
-func ListItems(db *gorm.DB, alias string, offest, limit int) ([]DBItem, error) {
-	var ids []int
-	for i := 0; i < limit; i++ {
-		ids = append(ids, i+offest)
-	}
+// UpdateItemData 修改事件保存的数据
+func UpdateItemData(db *gorm.DB, alias string, id uint, others []byte) error {
+	rst := dyncTable(db, alias).Where("id = ?", id).Update("others", others)
+	return rst.Error
+}
+
+// ListItems 按id顺序返回id大于afterID的记录。重组回滚会删除记录，id不一定连续
+func ListItems(db *gorm.DB, alias string, afterID, limit int) ([]DBItem, error) {
 	var out []DBItem
-	rst := dyncTable(db, alias).Find(&out, ids)
+	rst := dyncTable(db, alias).Where("id > ?", afterID).Order("id").Limit(limit).Find(&out)
+	return out, rst.Error
+}
 
+// ListItemsByTime 查询区块时间在[from,to]之间且id大于afterID的记录，to为0表示不限制
+func ListItemsByTime(db *gorm.DB, alias string, from, to uint64, afterID uint, limit int) ([]DBItem, error) {
+	var out []DBItem
+	tx := dyncTable(db, alias).Where("id > ? AND block_time >= ?", afterID, from)
+	if to > 0 {
+		tx = tx.Where("block_time <= ?", to)
+	}
+	rst := tx.Order("id").Limit(limit).Find(&out)
 	return out, rst.Error
 }
 
@@ -93,6 +142,27 @@ func DeleteItem(db *gorm.DB, alias string, id uint) error {
 	return rst.Error
 }
 
+func RemoveItem(db *gorm.DB, alias string, id uint) error {
+	rst := dyncTable(db, alias).Unscoped().Delete(&DBItem{}, id)
+	return rst.Error
+}
+
+func RemoveItemByTX(db *gorm.DB, alias string, tx string, logIndex uint) error {
+	rst := dyncTable(db, alias).Unscoped().Where("tx = ? AND log_index = ?", tx, logIndex).Delete(&DBItem{})
+	return rst.Error
+}
+
+// ListItemsBefore 按id倒序返回小于beforeID的记录，beforeID为0时从最新的记录开始
+func ListItemsBefore(db *gorm.DB, alias string, beforeID uint, limit int) ([]DBItem, error) {
+	var out []DBItem
+	tx := dyncTable(db, alias)
+	if beforeID > 0 {
+		tx = tx.Where("id < ?", beforeID)
+	}
+	rst := tx.Order("id desc").Limit(limit).Find(&out)
+	return out, rst.Error
+}
+
 func ItemsTotal(db *gorm.DB, alias string) (uint, error) {
 	var it DBItem
 	rst := dyncTable(db, alias).Last(&it)
@@ -137,8 +207,10 @@ func SetNotifyRecord(db *gorm.DB, alias string, nid uint) error {
 
 type BlockRecord struct {
 	gorm.Model
-	Alias   string `gorm:"uniqueIndex;column:alias"`
-	BlockID uint64 `gorm:"column:block_id"`
+	Alias     string `gorm:"uniqueIndex;column:alias"`
+	BlockID   uint64 `gorm:"column:block_id"`
+	BlockHash string `gorm:"column:block_hash"`
+	Step      uint64 `gorm:"column:step"`
 }
 
 func CreateBlockRecord(db *gorm.DB) error {
@@ -146,16 +218,25 @@ func CreateBlockRecord(db *gorm.DB) error {
 }
 
 func GetBlockRecord(db *gorm.DB, alias string) (uint64, error) {
+	bn, _, err := GetBlockRecordWithHash(db, alias)
+	return bn, err
+}
+
+func GetBlockRecordWithHash(db *gorm.DB, alias string) (uint64, string, error) {
 	var out BlockRecord
 	rst := db.Model(&BlockRecord{}).Where("alias = ?", alias).First(&out)
 	if errors.Is(rst.Error, gorm.ErrRecordNotFound) {
-		return 0, nil
+		return 0, "", nil
 	}
 
-	return out.BlockID, rst.Error
+	return out.BlockID, out.BlockHash, rst.Error
 }
 
 func SetBlockRecord(db *gorm.DB, alias string, blockID uint64) error {
+	return SetBlockRecordWithHash(db, alias, blockID, "")
+}
+
+func SetBlockRecordWithHash(db *gorm.DB, alias string, blockID uint64, hash string) error {
 	var record BlockRecord
 	db.Model(&BlockRecord{}).Where("alias = ?", alias).First(&record)
 	if blockID <= record.BlockID {
@@ -163,10 +244,104 @@ func SetBlockRecord(db *gorm.DB, alias string, blockID uint64) error {
 	}
 	record.Alias = alias
 	record.BlockID = blockID
+	record.BlockHash = hash
 	if record.ID == 0 {
 		rst := db.Create(&record)
 		return rst.Error
 	}
-	rst := db.Model(&BlockRecord{}).Where("alias = ?", alias).Where("block_id < ?", blockID).Update("block_id", blockID)
+	rst := db.Model(&BlockRecord{}).Where("alias = ?", alias).Where("block_id < ?", blockID).
+		Updates(map[string]interface{}{"block_id": blockID, "block_hash": hash})
+	return rst.Error
+}
+
+// ResetBlockRecord 回滚区块记录，用于链重组后重新处理
+func ResetBlockRecord(db *gorm.DB, alias string, blockID uint64, hash string) error {
+	rst := db.Model(&BlockRecord{}).Where("alias = ?", alias).
+		Updates(map[string]interface{}{"block_id": blockID, "block_hash": hash})
+	return rst.Error
+}
+
+// GetBlockStep 获取自适应学习到的每次请求的区块数，0表示没有记录
+func GetBlockStep(db *gorm.DB, alias string) (uint64, error) {
+	var out BlockRecord
+	rst := db.Model(&BlockRecord{}).Where("alias = ?", alias).First(&out)
+	if errors.Is(rst.Error, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return out.Step, rst.Error
+}
+
+func SetBlockStep(db *gorm.DB, alias string, step uint64) error {
+	rst := db.Model(&BlockRecord{}).Where("alias = ?", alias).Update("step", step)
+	if rst.Error != nil || rst.RowsAffected > 0 {
+		return rst.Error
+	}
+	rst = db.Create(&BlockRecord{Alias: alias, Step: step})
+	return rst.Error
+}
+
+// ContractRecord 通过工厂合约事件发现的子合约，Pending表示还没有补齐创建区块之后的事件，
+// SyncedBlock为已经补齐的最后一个区块
+type ContractRecord struct {
+	gorm.Model
+	Alias       string `gorm:"uniqueIndex:idx_alias_contract;column:alias"`
+	Address     string `gorm:"uniqueIndex:idx_alias_contract;column:address"`
+	BlockID     uint64 `gorm:"column:block_id"`
+	SyncedBlock uint64 `gorm:"column:synced_block"`
+	Pending     bool   `gorm:"column:pending"`
+}
+
+func CreateContractRecord(db *gorm.DB) error {
+	return db.AutoMigrate(&ContractRecord{})
+}
+
+// AddContractRecord 记录子合约，已存在时返回false
+func AddContractRecord(db *gorm.DB, alias, address string, blockID uint64) (bool, error) {
+	var record ContractRecord
+	db.Model(&ContractRecord{}).Where("alias = ? AND address = ?", alias, address).First(&record)
+	if record.ID > 0 {
+		return false, nil
+	}
+	rst := db.Create(&ContractRecord{Alias: alias, Address: address, BlockID: blockID, Pending: true})
+	return rst.Error == nil, rst.Error
+}
+
+// UpdateContractRecord 记录子合约补齐的进度
+func UpdateContractRecord(db *gorm.DB, alias, address string, synced uint64, pending bool) error {
+	rst := db.Model(&ContractRecord{}).Where("alias = ? AND address = ?", alias, address).
+		Updates(map[string]interface{}{"synced_block": synced, "pending": pending})
 	return rst.Error
 }
+
+func ListContractRecords(db *gorm.DB, alias string) ([]ContractRecord, error) {
+	var out []ContractRecord
+	rst := db.Model(&ContractRecord{}).Where("alias = ?", alias).Order("id").Find(&out)
+	return out, rst.Error
+}
+
+// TokenRecord 代币的symbol和decimals，Valid为false表示合约不是标准的代币
+type TokenRecord struct {
+	gorm.Model
+	ChainID  uint64 `gorm:"uniqueIndex:idx_chain_token;column:chain_id"`
+	Address  string `gorm:"uniqueIndex:idx_chain_token;column:address"`
+	Symbol   string `gorm:"column:symbol"`
+	Decimals uint8  `gorm:"column:decimals"`
+	Valid    bool   `gorm:"column:valid"`
+}
+
+func CreateTokenRecord(db *gorm.DB) error {
+	return db.AutoMigrate(&TokenRecord{})
+}
+
+func GetTokenRecord(db *gorm.DB, chainID uint64, address string) (*TokenRecord, error) {
+	var out TokenRecord
+	rst := db.Model(&TokenRecord{}).Where("chain_id = ? AND address = ?", chainID, address).First(&out)
+	if rst.Error != nil {
+		return nil, rst.Error
+	}
+	return &out, nil
+}
+
+func SetTokenRecord(db *gorm.DB, record *TokenRecord) error {
+	return db.Create(record).Error
+}
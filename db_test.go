@@ -2,6 +2,8 @@ package contractevent
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
@@ -53,6 +55,19 @@ func TestCreateTable(t *testing.T) {
 		t.Fatal(items)
 	}
 
+	item3 := DBItem{TX: "0x5678", LogIndex: 1, BlockTime: 1700000000, Others: []byte{11, 22}}
+	_, err = InsertItem(db, alias, item3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err = ListItemsByTime(db, alias, 1700000000, 0, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].TX != "0x5678" {
+		t.Fatal(items)
+	}
+
 }
 
 func TestSetNotifyRecord(t *testing.T) {
@@ -96,3 +111,155 @@ func TestSetNotifyRecord(t *testing.T) {
 		t.Fatal("error id,hope:100,get:", id)
 	}
 }
+
+func TestResetBlockRecord(t *testing.T) {
+	dbName := "gorm_test2.db"
+	os.Remove(dbName)
+	defer os.Remove(dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	CreateBlockRecord(db)
+	ldb, _ := db.DB()
+	defer ldb.Close()
+	alias := "alias002"
+	err = SetBlockRecordWithHash(db, alias, 100, "0x100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetBlockRecordWithHash(db, alias, 90, "0x90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bn, hash, err := GetBlockRecordWithHash(db, alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bn != 100 || hash != "0x100" {
+		t.Fatal("error record,hope:100,get:", bn, hash)
+	}
+
+	err = ResetBlockRecord(db, alias, 80, "0x80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bn, hash, err = GetBlockRecordWithHash(db, alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bn != 80 || hash != "0x80" {
+		t.Fatal("error record,hope:80,get:", bn, hash)
+	}
+}
+
+func TestContractRecord(t *testing.T) {
+	dbName := "gorm_test3.db"
+	os.Remove(dbName)
+	defer os.Remove(dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	CreateContractRecord(db)
+	ldb, _ := db.DB()
+	defer ldb.Close()
+	alias := "alias003"
+	created, err := AddContractRecord(db, alias, "0x01", 100)
+	if err != nil || !created {
+		t.Fatal("fail to add contract record:", created, err)
+	}
+	created, _ = AddContractRecord(db, alias, "0x01", 120)
+	if created {
+		t.Fatal("hope exist record")
+	}
+	err = UpdateContractRecord(db, alias, "0x01", 150, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := ListContractRecords(db, alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].BlockID != 100 || records[0].SyncedBlock != 150 || !records[0].Pending {
+		t.Fatal("error records:", records)
+	}
+	UpdateContractRecord(db, alias, "0x01", 200, false)
+	records, _ = ListContractRecords(db, alias)
+	if len(records) != 1 || records[0].Pending {
+		t.Fatal("hope finished:", records)
+	}
+}
+
+func TestNotifyWithGap(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alias := "gap"
+	CreateEventTable(db, alias)
+	CreateNotifyRecord(db)
+	for i := 1; i <= 25; i++ {
+		_, err = InsertItem(db, alias, DBItem{TX: fmt.Sprintf("0x%x", i), Others: []byte(`{}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 重组回滚删除的记录使id不连续
+	for id := uint(10); id <= 21; id++ {
+		RemoveItem(db, alias, id)
+	}
+	SetNotifyRecord(db, alias, 9)
+	var posted int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted++
+	}))
+	defer server.Close()
+	task := NewNotifyTask(db, alias, server.URL)
+	err = task.Run(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nid, _ := GetNotifyRecord(db, alias)
+	if nid != 25 || posted != 4 {
+		t.Fatal("error notify:", nid, posted)
+	}
+	// 重复的事件不占用新的id
+	id, err := InsertItem(db, alias, DBItem{TX: "0x1", Others: []byte(`{}`)})
+	if err != nil || id != 1 {
+		t.Fatal("error insert:", id, err)
+	}
+	_, err = InsertItem(db, alias, DBItem{TX: "0x100", Others: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _ := ListItems(db, alias, 25, 10)
+	if len(items) != 1 || items[0].ID != 26 {
+		t.Fatal("error items:", items)
+	}
+}
+
+func TestCreateTableIndexes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alias := "legacy"
+	err = dyncTable(db, alias).AutoMigrate(&DBItem{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 旧版本的唯一索引不包含sub_index
+	db.Exec("CREATE UNIQUE INDEX idx_tx_event_legacy ON event_legacy(tx,log_index)")
+	for i := 0; i < 2; i++ {
+		err = CreateEventTable(db, alias)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := dyncTable(db, alias).Migrator()
+	if m.HasIndex(&DBItem{}, "idx_tx_event_legacy") || !m.HasIndex(&DBItem{}, "idx_tx_sub_event_legacy") ||
+		!m.HasIndex(&DBItem{}, "idx_time_event_legacy") {
+		t.Fatal("error indexes")
+	}
+}
@@ -0,0 +1,153 @@
+package contractevent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+// 节点失败后的惩罚时间，期间优先使用其他节点
+const endpointFailPenalty = 30 * time.Second
+
+type endpoint struct {
+	url      string
+	source   LogSource
+	mu       sync.Mutex
+	latency  float64 // 毫秒，指数移动平均
+	errRate  float64 // 指数移动平均
+	head     uint64
+	requests uint64
+	errors   uint64
+	lastFail time.Time
+	limiter  *rateLimiter
+}
+
+type EndpointHealth struct {
+	URL       string  `json:"url"`
+	LatencyMS float64 `json:"latency_ms"`
+	ErrorRate float64 `json:"error_rate"`
+	Head      uint64  `json:"head"`
+	Requests  uint64  `json:"requests"`
+	Errors    uint64  `json:"errors"`
+}
+
+func newEndpoint(rawURL string, conf ChainConfig) (*endpoint, error) {
+	out := endpoint{url: rawURL, limiter: newRateLimiter(conf.RateLimit, conf.RateBurst)}
+	var options []rpc.ClientOption
+	if strings.HasPrefix(rawURL, "http") {
+		transport := &retryAfterTransport{base: http.DefaultTransport, onLimit: func(d time.Duration) {
+			log.Warnln("rpc node rate limited, pause:", maskURL(rawURL), d)
+			out.limiter.Pause(d)
+		}}
+		options = append(options, rpc.WithHTTPClient(&http.Client{Transport: transport}))
+	}
+	client, err := rpc.DialOptions(context.Background(), rawURL, options...)
+	if err != nil {
+		return nil, err
+	}
+	out.source = ethclient.NewClient(client)
+	return &out, nil
+}
+
+func newSourceEndpoint(name string, source LogSource, conf ChainConfig) *endpoint {
+	return &endpoint{url: name, source: source, limiter: newRateLimiter(conf.RateLimit, conf.RateBurst)}
+}
+
+func (e *endpoint) record(cost time.Duration, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requests++
+	ms := float64(cost) / float64(time.Millisecond)
+	if e.requests == 1 {
+		e.latency = ms
+	} else {
+		e.latency = e.latency*0.8 + ms*0.2
+	}
+	var fail float64
+	if err != nil && isEndpointError(err) {
+		fail = 1
+		e.errors++
+		e.lastFail = time.Now()
+	}
+	e.errRate = e.errRate*0.9 + fail*0.1
+}
+
+func (e *endpoint) setHead(head uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if head > e.head {
+		e.head = head
+	}
+}
+
+// score 分数越低越健康：延迟、错误率、落后的区块数以及最近是否失败
+func (e *endpoint) score(head uint64) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := (e.latency + 1) * (1 + 10*e.errRate)
+	if head > e.head {
+		s += float64(head-e.head) * 100
+	}
+	if time.Since(e.lastFail) < endpointFailPenalty {
+		s += 10000
+	}
+	return s
+}
+
+func (e *endpoint) health() EndpointHealth {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return EndpointHealth{
+		URL:       maskURL(e.url),
+		LatencyMS: e.latency,
+		ErrorRate: e.errRate,
+		Head:      e.head,
+		Requests:  e.requests,
+		Errors:    e.errors,
+	}
+}
+
+// isEndpointError 判断是否为节点本身的问题（网络、限流、服务异常），
+// 节点正常返回的JSON-RPC错误（如参数错误）换节点也没用，
+// 查询区间过大的错误需要缩小区间，不切换节点也不惩罚节点；
+// 数据不存在（如被重组的区块hash、不支持的区块标签）和JSON解析错误也不是节点的问题
+func isEndpointError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, errNotSupported) || errors.Is(err, ethereum.NotFound) ||
+		isRangeError(err) {
+		return false
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return false
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return true
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		// -32005: limit exceeded
+		return rpcErr.ErrorCode() == -32005
+	}
+	return true
+}
+
+// maskURL 只保留scheme和host，节点地址中通常带有api key
+func maskURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "***"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,200 @@
+package contractevent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	EnrichTX      = "tx"
+	EnrichReceipt = "receipt"
+)
+
+const (
+	KTXFrom                   = "tx.from"
+	KTXTo                     = "tx.to"
+	KTXValue                  = "tx.value"
+	KTXNonce                  = "tx.nonce"
+	KReceiptGasUsed           = "receipt.gas_used"
+	KReceiptEffectiveGasPrice = "receipt.effective_gas_price"
+	KReceiptStatus            = "receipt.status"
+)
+
+const (
+	enrichCacheSize = 1024
+	maxBatchSize    = 100
+)
+
+type batchCaller interface {
+	BatchCallContext(ctx context.Context, b []rpc.BatchElem) error
+}
+
+type rpcTransaction struct {
+	From  common.Address  `json:"from"`
+	To    *common.Address `json:"to"`
+	Value *hexutil.Big    `json:"value"`
+	Nonce hexutil.Uint64  `json:"nonce"`
+}
+
+type rpcReceipt struct {
+	GasUsed           hexutil.Uint64 `json:"gasUsed"`
+	EffectiveGasPrice *hexutil.Big   `json:"effectiveGasPrice"`
+	Status            hexutil.Uint64 `json:"status"`
+}
+
+type txDetail struct {
+	tx      *rpcTransaction
+	receipt *rpcReceipt
+}
+
+// enricher 批量获取交易和收据信息，按交易hash缓存
+type enricher struct {
+	caller  batchCaller
+	tx      bool
+	receipt bool
+	size    int
+	cache   *lru.Cache[common.Hash, txDetail]
+}
+
+func newEnricher(client LogSource, options []string) (*enricher, error) {
+	if len(options) == 0 {
+		return nil, nil
+	}
+	out := enricher{size: enrichCacheSize, cache: lru.NewCache[common.Hash, txDetail](enrichCacheSize)}
+	for _, it := range options {
+		switch it {
+		case EnrichTX:
+			out.tx = true
+		case EnrichReceipt:
+			out.receipt = true
+		default:
+			return nil, fmt.Errorf("unknown enrich option:%s", it)
+		}
+	}
+	switch c := client.(type) {
+	case batchCaller:
+		out.caller = c
+	case rpcSource:
+		out.caller = c.Client()
+	default:
+		return nil, fmt.Errorf("client not support batch call")
+	}
+	return &out, nil
+}
+
+// prefetch 一次批量请求所有未缓存的交易，节点返回null（交易不存在或者节点未同步）时返回错误，由调用者重试
+func (en *enricher) prefetch(hashes []common.Hash) error {
+	var batch []rpc.BatchElem
+	var details []*txDetail
+	var keys []common.Hash
+	seen := make(map[common.Hash]bool)
+	for _, hash := range hashes {
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		// Get更新缓存的顺序，避免本批次已缓存的交易被新加入的交易淘汰
+		if _, ok := en.cache.Get(hash); ok {
+			continue
+		}
+		detail := new(txDetail)
+		if en.tx {
+			batch = append(batch, rpc.BatchElem{Method: "eth_getTransactionByHash", Args: []interface{}{hash}, Result: &detail.tx})
+		}
+		if en.receipt {
+			batch = append(batch, rpc.BatchElem{Method: "eth_getTransactionReceipt", Args: []interface{}{hash}, Result: &detail.receipt})
+		}
+		details = append(details, detail)
+		keys = append(keys, hash)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	for start := 0; start < len(batch); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(batch) {
+			end = len(batch)
+		}
+		err := en.caller.BatchCallContext(context.Background(), batch[start:end])
+		if err != nil {
+			return err
+		}
+	}
+	for _, it := range batch {
+		if it.Error != nil {
+			return it.Error
+		}
+	}
+	for i, hash := range keys {
+		if en.tx && details[i].tx == nil {
+			return fmt.Errorf("not found transaction:%s", hash.Hex())
+		}
+		if en.receipt && details[i].receipt == nil {
+			return fmt.Errorf("not found receipt:%s", hash.Hex())
+		}
+	}
+	// 缓存至少能容纳一个批次的交易，否则处理日志时需要逐个重新获取
+	en.grow(len(seen))
+	for i, hash := range keys {
+		en.cache.Add(hash, *details[i])
+	}
+	return nil
+}
+
+// grow 扩大缓存的容量，保留已缓存的交易
+func (en *enricher) grow(size int) {
+	if size <= en.size {
+		return
+	}
+	cache := lru.NewCache[common.Hash, txDetail](size)
+	// Keys按从旧到新的顺序返回
+	for _, hash := range en.cache.Keys() {
+		if detail, ok := en.cache.Peek(hash); ok {
+			cache.Add(hash, detail)
+		}
+	}
+	en.size = size
+	en.cache = cache
+}
+
+func (en *enricher) enrich(info map[string]interface{}, hash common.Hash) error {
+	detail, ok := en.cache.Get(hash)
+	if !ok {
+		err := en.prefetch([]common.Hash{hash})
+		if err != nil {
+			log.Warnln("fail to get transaction:", hash.Hex(), err)
+			return err
+		}
+		detail, _ = en.cache.Get(hash)
+	}
+	if detail.tx != nil {
+		info[KTXFrom] = detail.tx.From
+		if detail.tx.To != nil {
+			info[KTXTo] = *detail.tx.To
+		}
+		info[KTXValue] = (*big.Int)(detail.tx.Value)
+		info[KTXNonce] = uint64(detail.tx.Nonce)
+	}
+	if detail.receipt != nil {
+		info[KReceiptGasUsed] = uint64(detail.receipt.GasUsed)
+		info[KReceiptEffectiveGasPrice] = (*big.Int)(detail.receipt.EffectiveGasPrice)
+		info[KReceiptStatus] = uint64(detail.receipt.Status)
+	}
+	return nil
+}
+
+func txHashes(logs []types.Log) []common.Hash {
+	out := make([]common.Hash, 0, len(logs))
+	for _, it := range logs {
+		out = append(out, it.TxHash)
+	}
+	return out
+}
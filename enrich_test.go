@@ -0,0 +1,72 @@
+package contractevent
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// txCaller 模拟节点的批量请求，missing中的交易返回null
+type txCaller struct {
+	calls   int
+	missing map[common.Hash]bool
+}
+
+func (c *txCaller) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	for i, it := range b {
+		c.calls++
+		hash := it.Args[0].(common.Hash)
+		data := []byte(`{"from":"0x1111111111111111111111111111111111111111","value":"0x1","nonce":"0x2"}`)
+		if c.missing[hash] {
+			data = []byte("null")
+		}
+		b[i].Error = json.Unmarshal(data, it.Result)
+	}
+	return nil
+}
+
+func TestEnricher(t *testing.T) {
+	caller := &txCaller{missing: make(map[common.Hash]bool)}
+	en := &enricher{caller: caller, tx: true, size: 2}
+	en.cache = lru.NewCache[common.Hash, txDetail](en.size)
+	var hashes []common.Hash
+	for i := 1; i <= 5; i++ {
+		hashes = append(hashes, common.BigToHash(newBig(uint64(i))))
+	}
+	err := en.prefetch(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 缓存可以容纳整个批次，处理日志时不再请求节点
+	for _, hash := range hashes {
+		info := make(map[string]interface{})
+		err = en.enrich(info, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info[KTXNonce] != uint64(2) {
+			t.Fatal("error info:", info)
+		}
+	}
+	if caller.calls != len(hashes) {
+		t.Fatal("error calls:", caller.calls)
+	}
+
+	// 节点返回null时返回错误，不缓存空的交易
+	missing := common.BigToHash(newBig(100))
+	caller.missing[missing] = true
+	err = en.enrich(make(map[string]interface{}), missing)
+	if err == nil {
+		t.Fatal("hope error of missing transaction")
+	}
+	delete(caller.missing, missing)
+	info := make(map[string]interface{})
+	err = en.enrich(info, missing)
+	if err != nil || info[KTXFrom] == nil {
+		t.Fatal("error retry:", info, err)
+	}
+}
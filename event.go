@@ -6,13 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/big"
-	"os"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/core/types"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
@@ -22,7 +23,27 @@ type Event struct {
 	cb     EventCallback
 	query  ethereum.FilterQuery
 	eABI   abi.ABI
-	client *ethclient.Client
+	client LogSource
+	times  blockTimer
+	enrich *enricher
+	shared *logFetcher
+	hooks  []EventCallback
+	native *nativeScanner
+	proxy  *proxyResolver
+	tokens *tokenMeta
+	where  exprNode
+	trans  *transform
+	// processors 依次执行的脚本和插件
+	processors []processor
+	dynamic    bool
+	// 新发现的合约及其创建的区块
+	discovered map[common.Address]uint64
+	mu         sync.RWMutex
+	chainID    uint64
+}
+
+type blockTimer interface {
+	BlockTime(ctx context.Context, hash common.Hash) (uint64, error)
 }
 
 type EventCallback func(alias string, info map[string]interface{}) error
@@ -38,115 +59,534 @@ const (
 	KEventName   = "event_name"
 	KRawData     = "raw_data"
 	KDBIndex     = "db_index"
+	KRemoved     = "removed"
+	KChainID     = "chain_id"
+	KBlockTime   = "block_time"
 )
 
-func NewEventWithDB(conf SubscriptionConf, client *ethclient.Client, db *gorm.DB) (*Event, error) {
-	err := CreateEventTable(db, conf.Alias)
+func NewEventWithDB(conf SubscriptionConf, client LogSource, db *gorm.DB) (*Event, error) {
+	// 不同链上的订阅可以使用相同的alias，表名使用Key()区分
+	key := conf.Key()
+	err := CreateEventTable(db, key)
 	if err != nil {
-		log.Warnln("fail to create database table of event ", conf.Alias, err)
+		log.Warnln("fail to create database table of event ", key, err)
 	}
-	return NewEvent(conf, client, func(alias string, info map[string]interface{}) error {
+	out, err := NewEvent(conf, client, func(_ string, info map[string]interface{}) error {
+		alias := key
+		if removed, _ := info[KRemoved].(bool); removed {
+			err := RemoveItemByTX(db, alias, info[KTX].(string), info[KLogIndex].(uint))
+			log.Infoln("remove event:", alias, info[KTX], info[KLogIndex], err)
+			return err
+		}
 		var item DBItem
-		lastID, _ := ItemsTotal(db, alias)
-		info[KDBIndex] = lastID + 1
 		item.TX = info[KTX].(string)
 		item.LogIndex = info[KLogIndex].(uint)
+		item.SubIndex, _ = info[KSubIndex].(uint)
+		item.BlockTime, _ = info[KBlockTime].(uint64)
 		item.Others, _ = json.Marshal(info)
-		id, err := InsertItem(db, alias, item)
+		var id uint
+		// id不一定连续，db_index使用插入后实际的id
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			id, err = InsertItem(tx, alias, item)
+			if err != nil || conf.Transform.drops(KDBIndex) {
+				return err
+			}
+			info[KDBIndex] = id
+			others, _ := json.Marshal(info)
+			return UpdateItemData(tx, alias, id, others)
+		})
 		log.Infoln("new event:", alias, id, item.TX, item.LogIndex, err)
 		return err
 	})
+	if err == nil && out.tokens != nil {
+		CreateTokenRecord(db)
+		out.tokens.db = db
+	}
+	return out, err
 }
 
-func NewEvent(conf SubscriptionConf, client *ethclient.Client, cb EventCallback) (*Event, error) {
+func NewEvent(conf SubscriptionConf, client LogSource, cb EventCallback) (*Event, error) {
 	var out Event
 	out.conf = conf
 	out.cb = cb
-	data, err := os.ReadFile(conf.ABIFile)
+	out.client = client
+	where, err := compileExpr(conf.Where)
+	if err != nil {
+		log.Errorln("fail to compile where:", conf.Alias, conf.Where, err)
+		return nil, err
+	}
+	out.where = where
+	out.trans, err = newTransform(conf.Transform)
 	if err != nil {
-		data = GetABIData(conf.ABIFile)
-		if len(data) == 0 {
-			log.Errorln("fail to open abi file:", conf.ABIFile, err)
+		log.Errorln("error transform:", conf.Alias, err)
+		return nil, err
+	}
+	if conf.Script != "" {
+		script, err := newScriptProcessor(conf.Script, conf.ScriptTimeout)
+		if err != nil {
+			log.Errorln("fail to load script:", conf.Alias, conf.Script, err)
 			return nil, err
 		}
+		out.processors = append(out.processors, script)
+	}
+	if conf.Plugin != "" {
+		plugin, err := newWASMProcessor(conf.Plugin, conf.PluginFunc, conf.PluginTimeout)
+		if err != nil {
+			log.Errorln("fail to load plugin:", conf.Alias, conf.Plugin, err)
+			return nil, err
+		}
+		out.processors = append(out.processors, plugin)
+	}
+	switch conf.Type {
+	case "", TypeEvent:
+		err = out.loadABI()
+	case TypeNative:
+		out.native, err = newNativeScanner(conf, client)
+	default:
+		err = fmt.Errorf("unknown subscription type:%s", conf.Type)
 	}
-	cAbi, err := abi.JSON(bytes.NewReader(data))
 	if err != nil {
-		log.Errorln("fail to load abi:", conf.ABIFile, err)
 		return nil, err
 	}
-
-	out.query, err = newQuery(conf, cAbi)
+	if bt, ok := client.(blockTimer); ok {
+		out.times = bt
+	} else {
+		out.times = newHeaderCache(client, 0)
+	}
+	switch conf.Ingest {
+	case "", IngestLogs:
+	case IngestReceipts:
+		if _, ok := client.(receiptSource); !ok {
+			log.Errorln("the log source not support block receipts:", conf.Alias)
+			return nil, errNotSupported
+		}
+	default:
+		return nil, fmt.Errorf("unknown ingest:%s", conf.Ingest)
+	}
+	out.enrich, err = newEnricher(client, conf.Enrich)
 	if err != nil {
+		log.Errorln("fail to enrich:", conf.Alias, err)
 		return nil, err
 	}
+	id, err := client.ChainID(context.Background())
+	if err != nil {
+		log.Errorln("fail to get chain id:", conf.Alias, err)
+		return nil, err
+	}
+	out.chainID = id.Uint64()
+	if conf.TokenMeta {
+		out.tokens, err = newTokenMeta(conf, client)
+		if err != nil {
+			log.Errorln("the log source not support eth_call:", conf.Alias, err)
+			return nil, err
+		}
+		out.tokens.chainID = out.chainID
+		err = conf.Transform.protect(out.tokens.field)
+		if err != nil {
+			log.Errorln("error transform:", conf.Alias, err)
+			return nil, err
+		}
+	}
+
+	return &out, nil
+}
+
+// loadABI 加载ABI并生成查询条件
+func (e *Event) loadABI() error {
+	conf := e.conf
+	var files []string
+	if conf.ABIFile != "" {
+		files = append(files, conf.ABIFile)
+	}
+	files = append(files, conf.ABIFiles...)
+	if len(files) == 0 && len(conf.Signatures) == 0 {
+		log.Errorln("not found abi file:", conf.Alias)
+		return fmt.Errorf("not found abi file:%s", conf.Alias)
+	}
+	data, err := mergeABI(files, conf.Signatures)
+	if err != nil {
+		log.Errorln("fail to load abi:", files, conf.Signatures, err)
+		return err
+	}
+	cAbi, err := parseABI(data)
+	if err != nil {
+		log.Errorln("fail to load abi:", files, conf.Signatures, err)
+		return err
+	}
+
+	e.query, err = newQuery(conf, cAbi)
+	if err != nil {
+		return err
+	}
+	e.eABI = cAbi
+	if conf.Proxy {
+		e.proxy, err = newProxyResolver(conf, e.client, cAbi)
+		if err != nil {
+			log.Errorln("fail to resolve proxy:", conf.Alias, err)
+			return err
+		}
+		// 同时监听Upgraded事件，indexed的filter对Upgraded不适用，只在解析后检查
+		if len(e.query.Topics) > 0 {
+			ids := append(slices.Clone(e.query.Topics[0]), upgradedTopic)
+			e.query.Topics = [][]common.Hash{ids}
+		}
+	}
+	return nil
+}
 
+// parseABI 解析ABI，事件同时以topic为key保存，indexed参数按顺序解析topics
+func parseABI(data []byte) (abi.ABI, error) {
+	items, err := normalizeABI(data)
+	if err != nil {
+		return abi.ABI{}, err
+	}
+	data, _ = json.Marshal(items)
+	cAbi, err := abi.JSON(bytes.NewReader(data))
+	if err != nil {
+		return cAbi, err
+	}
 	for _, event := range cAbi.Events {
 		if _, ok := cAbi.Events[event.ID.Hex()]; ok {
 			continue
 		}
+		// 复制参数，不影响以名称为key的事件
+		inputs := make(abi.Arguments, len(event.Inputs))
 		for i, it := range event.Inputs {
-			if it.Indexed {
-				it.Indexed = false
-				event.Inputs[i] = it
-			}
+			it.Indexed = false
+			inputs[i] = it
 		}
+		event.Inputs = inputs
 		cAbi.Events[event.ID.Hex()] = event
 	}
-
-	out.eABI = cAbi
-	out.client = client
-
-	return &out, nil
+	return cAbi, nil
 }
 
 func (e *Event) Run(start, end uint64) error {
-	query := e.query
-	query.FromBlock = new(big.Int).SetUint64(start)
-	query.ToBlock = new(big.Int).SetUint64(end)
-	logs, err := e.client.FilterLogs(context.Background(), query)
+	for start <= end {
+		last, _, err := e.run(start, end)
+		if err != nil {
+			return err
+		}
+		start = last + 1
+	}
+	return nil
+}
+
+// run 处理[start,end]区间的事件，使用共享查询时实际处理的区间可能更小，
+// 返回实际处理的最后一个区块和节点返回的日志数量，用于调整请求的区块区间
+func (e *Event) run(start, end uint64) (uint64, int, error) {
+	var logs []types.Log
+	var total int
+	var err error
+	if e.native != nil {
+		return e.native.run(e, start, end)
+	}
+	if e.dynamic && len(e.Query().Addresses) == 0 {
+		return end, 0, nil
+	}
+	if e.shared != nil {
+		logs, end, total, err = e.shared.fetch(e, start, end)
+	} else {
+		logs, err = e.fetchLogs(start, end)
+		total = len(logs)
+	}
 	if err != nil {
 		log.Errorln("fail to FilterLogs:", e.conf.Alias, err)
-		return err
+		return 0, 0, err
 	}
+	err = e.handle(logs)
+	if err != nil {
+		return 0, 0, err
+	}
+	return end, total, nil
+}
 
+// fetchLogs 获取[start,end]区间的日志，不使用共享查询
+func (e *Event) fetchLogs(start, end uint64) ([]types.Log, error) {
+	query := e.Query()
+	// 动态合约的订阅在发现合约之前不查询，避免查询所有合约
+	if e.dynamic && len(query.Addresses) == 0 {
+		return nil, nil
+	}
+	if e.conf.Ingest == IngestReceipts {
+		return e.receiptLogs(start, end)
+	}
+	query.FromBlock = newBig(start)
+	query.ToBlock = newBig(end)
+	return e.client.FilterLogs(context.Background(), query)
+}
+
+// handle 按顺序处理日志
+func (e *Event) handle(logs []types.Log) error {
+	if e.enrich != nil {
+		err := e.enrich.prefetch(txHashes(logs))
+		if err != nil {
+			log.Errorln("fail to get transactions:", e.conf.Alias, err)
+			return err
+		}
+	}
 	for _, vLog := range logs {
-		tid := vLog.Topics[0].Hex()
-		info := make(map[string]interface{})
-		info[KAlias] = e.conf.Alias
-		info[KContract] = vLog.Address.Hex()
-		info[KBlock] = vLog.BlockHash.Hex()
-		info[KBlockNumber] = vLog.BlockNumber
-		info[KTX] = vLog.TxHash.Hex()
-		info[KLogIndex] = vLog.Index
-		info[KTopic] = tid
-		info[KEventName] = e.eABI.Events[tid].Name
-		var data []byte
-		for i, t := range vLog.Topics {
-			if i == 0 {
+		_, err := e.process(vLog)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match 判断日志是否符合该订阅的query，用于分发共享查询的结果
+func (e *Event) match(vLog types.Log) bool {
+	return matchQuery(e.Query(), vLog)
+}
+
+func newBig(n uint64) *big.Int {
+	return new(big.Int).SetUint64(n)
+}
+
+// Subscribe 订阅实时的事件日志，节点需要支持订阅(ws/wss)
+func (e *Event) Subscribe(ctx context.Context, ch chan<- types.Log) (ethereum.Subscription, error) {
+	return e.client.SubscribeFilterLogs(ctx, e.Query(), ch)
+}
+
+// process 解析日志并通知callback，如果被filter过滤，返回的info为nil
+func (e *Event) process(vLog types.Log) (map[string]interface{}, error) {
+	if len(vLog.Topics) == 0 {
+		return nil, nil
+	}
+	tid := vLog.Topics[0].Hex()
+	info := make(map[string]interface{})
+	info[KAlias] = e.conf.Alias
+	info[KChainID] = e.chainID
+	info[KContract] = vLog.Address.Hex()
+	info[KBlock] = vLog.BlockHash.Hex()
+	info[KBlockNumber] = vLog.BlockNumber
+	info[KTX] = vLog.TxHash.Hex()
+	info[KLogIndex] = vLog.Index
+	info[KTopic] = tid
+	cAbi := e.eABI
+	if e.proxy != nil {
+		cAbi = e.proxy.resolve(vLog)
+		if e.proxy.hidden(vLog) {
+			return nil, nil
+		}
+	}
+	info[KEventName] = cAbi.Events[tid].Name
+	var data []byte
+	for i, t := range vLog.Topics {
+		if i == 0 {
+			continue
+		}
+		data = append(data, t.Bytes()...)
+	}
+	data = append(data, vLog.Data...)
+	err := cAbi.UnpackIntoMap(info, tid, data)
+	if err != nil {
+		log.Warnln("fail to UnpackIntoMap:", e.conf.Alias, info[KEventName], tid, len(data), err)
+		info[KRawData] = data
+	}
+	if vLog.Removed {
+		info[KRemoved] = true
+	} else {
+		// 先用解析后的字段过滤，被过滤的事件不需要获取区块时间和交易信息
+		if !e.prefilter(info) {
+			return nil, nil
+		}
+		bt, err := e.times.BlockTime(context.Background(), vLog.BlockHash)
+		if err != nil {
+			log.Warnln("fail to get block time:", e.conf.Alias, vLog.BlockNumber, err)
+			return nil, err
+		}
+		info[KBlockTime] = bt
+		if e.enrich != nil {
+			err = e.enrich.enrich(info, vLog.TxHash)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if e.tokens != nil && !vLog.Removed {
+		err = e.tokens.annotate(info, vLog.Address)
+		if err != nil {
+			log.Warnln("fail to get token metadata:", e.conf.Alias, vLog.Address.Hex(), err)
+			return nil, err
+		}
+	}
+	return e.emit(info)
+}
+
+// emit 检查filter并通知callback和hooks，如果被filter过滤，返回的info为nil
+func (e *Event) emit(info map[string]interface{}) (map[string]interface{}, error) {
+	if e.trans != nil {
+		e.trans.apply(info)
+	}
+	// 被移除的日志没有区块时间、交易等信息，不执行脚本和插件、不检查filter，确保之前保存的事件能被移除
+	removed, _ := info[KRemoved].(bool)
+	items := []map[string]interface{}{info}
+	if !removed {
+		for _, p := range e.processors {
+			var next []map[string]interface{}
+			for _, it := range items {
+				out, err := p.process(it)
+				if err != nil {
+					log.Errorln("fail to process event:", e.conf.Alias, info[KTX], err)
+					return nil, err
+				}
+				next = append(next, out...)
+			}
+			items = next
+		}
+		if len(items) > 1 {
+			for i, it := range items {
+				it[KSubIndex] = uint(i)
+			}
+		}
+	}
+	var out map[string]interface{}
+	for _, it := range items {
+		if !removed {
+			if err := e.filter(it); err != nil {
+				log.Infoln("filter limit:", err)
 				continue
 			}
-			data = append(data, t.Bytes()...)
 		}
-		data = append(data, vLog.Data...)
-		err := e.eABI.UnpackIntoMap(info, tid, data)
+		err := e.cb(e.conf.Alias, it)
 		if err != nil {
-			log.Warnln("fail to UnpackIntoMap:", e.conf.Alias, info[KEventName], tid, len(data), err)
-			info[KRawData] = data
+			return it, err
 		}
-		if len(e.conf.Filter) == 0 {
-			err = e.cb(e.conf.Alias, info)
+		for _, hook := range e.hooks {
+			err = hook(e.conf.Alias, it)
 			if err != nil {
-				return err
+				return it, err
 			}
-			continue
 		}
-		err = check(e.conf.Filter, info)
+		out = it
+	}
+	return out, nil
+}
+
+// prefilter 获取区块时间、交易和token信息之前提前检查filter，返回false表示事件一定会被过滤。
+// 只检查已经存在且不会被Transform修改的字段，Where引用的字段都满足条件时才提前求值，
+// 脚本和插件可以修改任意字段，有脚本或插件时不提前检查
+func (e *Event) prefilter(info map[string]interface{}) bool {
+	if len(e.processors) > 0 {
+		return true
+	}
+	ready := func(key string) bool {
+		_, ok := info[key]
+		return ok && e.conf.Transform.protect(key) == nil
+	}
+	name, _ := info[KEventName].(string)
+	for _, filter := range []map[string]string{e.conf.Filter, e.conf.Filters[name]} {
+		for key, value := range filter {
+			if ready(key) && checkValues(key, []string{value}, info) != nil {
+				return false
+			}
+		}
+	}
+	for key, values := range e.conf.FilterIn {
+		if ready(key) && checkValues(key, values, info) != nil {
+			return false
+		}
+	}
+	if e.where == nil {
+		return true
+	}
+	for _, key := range exprFields(e.where) {
+		if !ready(key) {
+			return true
+		}
+	}
+	// 求值出错时由filter记录日志
+	ok, err := evalBool(e.where, info)
+	return ok || err != nil
+}
+
+// filter 依次检查Filter、FilterIn、事件的Filters和Where表达式
+func (e *Event) filter(info map[string]interface{}) error {
+	err := check(e.conf.Filter, info)
+	if err != nil {
+		return err
+	}
+	err = checkIn(e.conf.FilterIn, info)
+	if err != nil {
+		return err
+	}
+	name, _ := info[KEventName].(string)
+	err = check(e.conf.Filters[name], info)
+	if err != nil || e.where == nil {
+		return err
+	}
+	ok, err := evalBool(e.where, info)
+	if err != nil {
+		log.Errorln("fail to eval where:", e.conf.Alias, e.conf.Where, err)
+		return err
+	}
+	if !ok {
+		err = fmt.Errorf("not match where:%s", e.conf.Where)
+	}
+	return err
+}
+
+func (e *Event) Query() ethereum.FilterQuery {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.query
+}
+
+// AddContract 增加监听的合约地址，已存在时返回false
+func (e *Event) AddContract(addr common.Address) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if slices.Contains(e.query.Addresses, addr) {
+		return false
+	}
+	addrs := make([]common.Address, 0, len(e.query.Addresses)+1)
+	addrs = append(addrs, e.query.Addresses...)
+	e.query.Addresses = append(addrs, addr)
+	return true
+}
+
+// Discover 发现新的合约，由订阅自己的处理协程通过takeDiscovered加入查询并补齐创建区块之后的事件，
+// 避免与正在处理的区间冲突
+func (e *Event) Discover(addr common.Address, block uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.discovered == nil {
+		e.discovered = make(map[common.Address]uint64)
+	}
+	if bn, ok := e.discovered[addr]; !ok || block < bn {
+		e.discovered[addr] = block
+	}
+}
+
+func (e *Event) takeDiscovered() map[common.Address]uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := e.discovered
+	e.discovered = nil
+	return out
+}
+
+// catchUp 只查询指定合约在[start,end]区间的事件，用于补齐新发现的合约
+func (e *Event) catchUp(addr common.Address, start, end uint64) error {
+	query := e.Query()
+	query.Addresses = []common.Address{addr}
+	query.FromBlock = newBig(start)
+	query.ToBlock = newBig(end)
+	logs, err := e.client.FilterLogs(context.Background(), query)
+	if err != nil {
+		log.Errorln("fail to FilterLogs:", e.conf.Alias, addr.Hex(), err)
+		return err
+	}
+	if e.enrich != nil {
+		err = e.enrich.prefetch(txHashes(logs))
 		if err != nil {
-			log.Infoln("filter limit:", err)
-			continue
+			return err
 		}
-		err = e.cb(e.conf.Alias, info)
+	}
+	for _, vLog := range logs {
+		_, err = e.process(vLog)
 		if err != nil {
 			return err
 		}
@@ -155,74 +595,122 @@ func (e *Event) Run(start, end uint64) error {
 }
 
 func check(filter map[string]string, info map[string]interface{}) error {
-	if len(filter) == 0 {
-		return nil
-	}
 	for key, value := range filter {
-		v, ok := info[key]
-		if !ok {
-			log.Debugln("filter check fail, hope exist the key:", key)
-			return fmt.Errorf("not exist key:%s", key)
+		err := checkValues(key, []string{value}, info)
+		if err != nil {
+			return err
 		}
-		bVal, _ := json.Marshal(v)
+	}
+	return nil
+}
 
-		if bVal[0] == '"' {
-			bVal = bVal[1 : len(bVal)-1]
-		}
-		if value != string(bVal) {
-			log.Debugf("filter check fail, different value, hope:%s,get:%s", value, bVal)
-			return fmt.Errorf("hope:%s,get:%s", value, bVal)
+// checkIn 参数的值匹配列表中任意一个即可，列表为空时只要求参数存在
+func checkIn(filter map[string][]string, info map[string]interface{}) error {
+	for key, values := range filter {
+		err := checkValues(key, values, info)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+func checkValues(key string, values []string, info map[string]interface{}) error {
+	v, ok := info[key]
+	if !ok {
+		log.Debugln("filter check fail, hope exist the key:", key)
+		return fmt.Errorf("not exist key:%s", key)
+	}
+	bVal, _ := json.Marshal(v)
+
+	if bVal[0] == '"' {
+		bVal = bVal[1 : len(bVal)-1]
+	}
+	if len(values) > 0 && !slices.Contains(values, string(bVal)) {
+		log.Debugf("filter check fail, different value, hope:%s,get:%s", values, bVal)
+		return fmt.Errorf("hope:%s,get:%s", values, bVal)
+	}
+	return nil
+}
+
 func newQuery(conf SubscriptionConf, cAbi abi.ABI) (ethereum.FilterQuery, error) {
 	query := ethereum.FilterQuery{}
 	for _, addr := range conf.Contract {
 		query.Addresses = append(query.Addresses, common.HexToAddress(addr))
 	}
 
-	e, ok := cAbi.Events[conf.EventName]
-	if !ok {
-		// 如果EventName为空，则表示监听合约的所有事件
-		if conf.EventName == "" {
-			return query, nil
+	names := conf.Events()
+	// 如果EventName为空，则表示监听合约的所有事件
+	if len(names) == 0 {
+		return query, nil
+	}
+	var ids []common.Hash
+	for _, name := range names {
+		e, ok := cAbi.Events[name]
+		if !ok {
+			// 如果非空，且没有找到，说明ABI文件有问题，没有对应事件的ABI
+			log.Errorln("not found the Event Name from ABI:", conf.Alias, name)
+			return query, fmt.Errorf("not found the Event Name from ABI:%s", name)
 		}
-		// 如果非空，且没有找到，说明ABI文件有问题，没有对应事件的ABI
-		log.Errorln("not found the Event Name from ABI:", conf.Alias, conf.EventName)
-		return query, fmt.Errorf("not found the Event Name from ABI:%s", conf.EventName)
+		ids = append(ids, e.ID)
 	}
 	// 只监听合约的指定事件
-	query.Topics = append(query.Topics, []common.Hash{e.ID})
+	query.Topics = append(query.Topics, ids)
+	// 多个事件的indexed参数位置不同，filter只在解析后检查
+	if len(names) > 1 {
+		return query, nil
+	}
+	e := cAbi.Events[names[0]]
+	// Filter和FilterIn都会在解析后检查，同一个参数优先使用Filter的单个值
+	filter := make(map[string][]string)
+	for k, v := range conf.FilterIn {
+		filter[k] = v
+	}
+	for k, v := range conf.Filter {
+		filter[k] = []string{v}
+	}
+	for k, v := range conf.Filters[names[0]] {
+		filter[k] = []string{v}
+	}
 	// 如果有filter，它对应的链上事件有indexed修饰，则可以直接添加到topics里，更确定性的过滤事件
-	if len(conf.Filter) > 0 {
+	if len(filter) > 0 {
 		for _, it := range e.Inputs {
 			if !it.Indexed {
 				break
 			}
-			fv := conf.Filter[it.Name]
-			if fv == "" {
-				query.Topics = append(query.Topics, []common.Hash{})
-				continue
-			}
-			if strings.HasPrefix(fv, "0x") {
-				query.Topics = append(query.Topics, []common.Hash{common.HexToHash(fv)})
-				continue
-			}
-			switch it.Type.T {
-			case abi.IntTy, abi.UintTy:
-				// 可能的场景：监听NFT的指定的tokenId的事件
-				bv, ok := new(big.Int).SetString(fv, 10)
-				if !ok {
+			var hashes []common.Hash
+			for _, fv := range filter[it.Name] {
+				if fv == "" {
+					continue
+				}
+				h, err := topicHash(it, fv)
+				if err != nil {
 					log.Errorln("error filter value,unable parse to big.int:", conf.Alias, it.Name, fv)
-					return query, fmt.Errorf("error filter value,key:%s,hope int value:%s", it.Name, fv)
+					return query, err
 				}
-				query.Topics = append(query.Topics, []common.Hash{common.BigToHash(bv)})
-			default:
-				query.Topics = append(query.Topics, []common.Hash{common.HexToHash(fv)})
+				hashes = append(hashes, h)
 			}
+			// 多个值时为OR关系，没有值时匹配任意值
+			query.Topics = append(query.Topics, hashes)
 		}
 	}
 	return query, nil
 }
+
+// topicHash 把filter的值转换为indexed参数对应的topic
+func topicHash(arg abi.Argument, fv string) (common.Hash, error) {
+	if strings.HasPrefix(fv, "0x") {
+		return common.HexToHash(fv), nil
+	}
+	switch arg.Type.T {
+	case abi.IntTy, abi.UintTy:
+		// 可能的场景：监听NFT的指定的tokenId的事件
+		bv, ok := new(big.Int).SetString(fv, 10)
+		if !ok {
+			return common.Hash{}, fmt.Errorf("error filter value,key:%s,hope int value:%s", arg.Name, fv)
+		}
+		return common.BigToHash(bv), nil
+	default:
+		return common.HexToHash(fv), nil
+	}
+}
@@ -0,0 +1,284 @@
+package contractevent
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"gopkg.in/yaml.v3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestEventNames(t *testing.T) {
+	source := loadTestSource(t)
+	sub := SubscriptionConf{
+		Alias:      "token",
+		Contract:   []string{"0x2260fac5e5542a773aa44fbcfedf7c193bc2c599"},
+		ABIFile:    ABIERC20,
+		EventNames: []string{"Transfer", "Approval"},
+	}
+	var events []map[string]interface{}
+	event := newTestEvent(t, sub, source, &events)
+	if len(event.Query().Topics) != 1 || len(event.Query().Topics[0]) != 2 {
+		t.Fatal("error topics:", event.Query().Topics)
+	}
+	event.Run(100, 102)
+	if len(events) != 3 || events[2][KEventName] != "Approval" {
+		t.Fatal("error events:", events)
+	}
+
+	events = nil
+	sub.Filters = map[string]map[string]string{
+		"Transfer": {"from": "0x2222222222222222222222222222222222222222"},
+	}
+	event = newTestEvent(t, sub, source, &events)
+	event.Run(100, 102)
+	if len(events) != 2 || events[0][KTX] != "0x0000000000000000000000000000000000000000000000000000000000000a02" ||
+		events[1][KEventName] != "Approval" {
+		t.Fatal("error filter events:", events)
+	}
+
+	sub.EventNames = []string{"Transfer", "NotExist"}
+	_, err := NewEvent(sub, source, func(alias string, info map[string]interface{}) error { return nil })
+	if err == nil {
+		t.Fatal("hope error of unknown event")
+	}
+}
+
+func TestFilterValues(t *testing.T) {
+	var sub SubscriptionConf
+	err := yaml.Unmarshal([]byte(`
+filter:
+  from: 0x2222222222222222222222222222222222222222
+filter_in:
+  to:
+    - 0x1111111111111111111111111111111111111111
+    - 0x3333333333333333333333333333333333333333
+`), &sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Filter["from"] != "0x2222222222222222222222222222222222222222" || len(sub.FilterIn["to"]) != 2 {
+		t.Fatal("error filter:", sub.Filter, sub.FilterIn)
+	}
+
+	source := loadTestSource(t)
+	sub.Alias = "token"
+	sub.ABIFile = ABIERC20
+	sub.EventName = "Transfer"
+	sub.Filter = nil
+	sub.FilterIn = map[string][]string{"from": {
+		"0x99ac8ca7087fa4a2a1fb6357269965a2014abc35",
+		"0x2222222222222222222222222222222222222222",
+	}}
+	var events []map[string]interface{}
+	event := newTestEvent(t, sub, source, &events)
+	if topics := event.Query().Topics; len(topics) != 3 || len(topics[1]) != 2 || len(topics[2]) != 0 {
+		t.Fatal("error topics:", topics)
+	}
+	event.Run(100, 102)
+	if len(events) != 2 {
+		t.Fatal("error events:", events)
+	}
+
+	// 非indexed参数使用相同的列表语义
+	events = nil
+	sub.FilterIn = map[string][]string{"value": {"1000", "3000"}}
+	event = newTestEvent(t, sub, source, &events)
+	event.Run(100, 102)
+	if len(events) != 1 || events[0][KBlockNumber] != uint64(101) {
+		t.Fatal("error value filter events:", events)
+	}
+}
+
+// headerCounter 统计获取区块头的次数
+type headerCounter struct {
+	*MemorySource
+	count int
+}
+
+func (s *headerCounter) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	s.count++
+	return s.MemorySource.HeaderByHash(ctx, hash)
+}
+
+func TestPrefilter(t *testing.T) {
+	source := loadTestSource(t)
+	cases := []struct {
+		where     string
+		transform *TransformConf
+		events    int
+		headers   int
+	}{
+		// 解析后的字段可以提前过滤，不需要获取被过滤事件的区块头
+		{"value > 1500", nil, 1, 1},
+		// 需要区块时间的表达式只在获取区块头后检查
+		{"block_time > 1700000020", nil, 1, 2},
+		// Transform会修改的字段不提前检查
+		{"value > 1500", &TransformConf{Set: map[string]string{"value": "1"}}, 0, 2},
+	}
+	for _, it := range cases {
+		counter := &headerCounter{MemorySource: source}
+		sub := SubscriptionConf{
+			Alias:     "token",
+			ABIFile:   ABIERC20,
+			EventName: "Transfer",
+			Where:     it.where,
+			Transform: it.transform,
+		}
+		var events []map[string]interface{}
+		event := newTestEvent(t, sub, counter, &events)
+		event.Run(100, 102)
+		if len(events) != it.events || counter.count != it.headers {
+			t.Error("error prefilter:", it.where, len(events), counter.count)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	source := loadTestSource(t)
+	sub := SubscriptionConf{
+		Alias:     "token",
+		ABIFile:   ABIERC20,
+		EventName: "Transfer",
+		Transform: &TransformConf{
+			Compute: []ComputeConf{
+				{Field: "amount", From: "value", Func: ComputeScale, Arg: "3"},
+				{Field: "sender", From: "from", Func: ComputeLower},
+			},
+			Rename: map[string]string{"to": "receiver"},
+			Set:    map[string]string{"tag": "wbtc"},
+			Drop:   []string{"from", KTopic},
+		},
+		Where: "amount > 1.5",
+	}
+	var events []map[string]interface{}
+	event := newTestEvent(t, sub, source, &events)
+	event.Run(100, 102)
+	if len(events) != 1 {
+		t.Fatal("error events:", events)
+	}
+	info := events[0]
+	if info["amount"] != "2" || info["sender"] != "0x2222222222222222222222222222222222222222" ||
+		info["receiver"] == nil || info["tag"] != "wbtc" {
+		t.Fatal("error transform:", info)
+	}
+	for _, key := range []string{"from", "to", KTopic} {
+		if _, ok := info[key]; ok {
+			t.Fatal("hope dropped:", key)
+		}
+	}
+
+	for _, it := range []*TransformConf{
+		{Drop: []string{KTX}},
+		{Rename: map[string]string{"to": KBlockNumber}},
+		{Set: map[string]string{KBlock: "0x01"}},
+		{Compute: []ComputeConf{{Field: KLogIndex, From: "value", Func: ComputeString}}},
+		{Rename: map[string]string{"from": "account", "to": "account"}},
+	} {
+		sub.Transform = it
+		_, err := NewEvent(sub, source, func(alias string, info map[string]interface{}) error { return nil })
+		if err == nil {
+			t.Fatal("hope error of transform:", it)
+		}
+	}
+	// 工厂订阅的合约字段、代币的金额字段不允许修改
+	trans := &TransformConf{Rename: map[string]string{"value": "amount"}, Set: map[string]string{"pair": "0x01"}}
+	if trans.protect("value") == nil || trans.protect("pair") == nil || trans.protect("to") != nil {
+		t.Fatal("error protect")
+	}
+}
+
+func TestScript(t *testing.T) {
+	source := loadTestSource(t)
+	sub := SubscriptionConf{
+		Alias:      "token",
+		ABIFile:    ABIERC20,
+		EventNames: []string{"Transfer", "Approval"},
+		Script:     "testdata/script.js",
+	}
+	var events []map[string]interface{}
+	event := newTestEvent(t, sub, source, &events)
+	err := event.Run(100, 102)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[0]["direction"] != "out" || events[1]["direction"] != "in" ||
+		events[1][KSubIndex] != uint(1) || events[2][KEventName] != "Approval" {
+		t.Fatal("error events:", events)
+	}
+	if v, _ := events[0]["value"].(*big.Int); v == nil || v.Int64() != 2000 {
+		t.Fatal("hope keep the type of value:", events[0]["value"])
+	}
+	if events[0][KLogIndex] != uint(0) {
+		t.Fatal("error log index:", events[0][KLogIndex])
+	}
+}
+
+func TestScriptTimeout(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "loop.js")
+	err := os.WriteFile(file, []byte("function process(info) { while (info.loop) {} info.ok = true }"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := newScriptProcessor(file, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = script.process(map[string]interface{}{KTX: "0x01", "loop": true})
+	if err == nil {
+		t.Fatal("hope timeout error")
+	}
+	// 超时后脚本仍可以继续使用
+	items, err := script.process(map[string]interface{}{KTX: "0x01"})
+	if err != nil || len(items) != 1 || items[0]["ok"] != true {
+		t.Fatal("error items:", items, err)
+	}
+}
+
+func TestEventWithDBSubIndex(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "event.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldb, _ := db.DB()
+	defer ldb.Close()
+	source := loadTestSource(t)
+	sub := SubscriptionConf{
+		Alias:      "token",
+		ABIFile:    ABIERC20,
+		EventNames: []string{"Transfer", "Approval"},
+		Script:     "testdata/script.js",
+	}
+	event, err := NewEventWithDB(sub, source, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 重复处理时不能插入重复的记录
+	for i := 0; i < 2; i++ {
+		err = event.Run(100, 102)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	items, err := ListItems(db, sub.Key(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 || items[0].TX != items[1].TX || items[0].SubIndex != 0 || items[1].SubIndex != 1 {
+		t.Fatal("error items:", items)
+	}
+	for _, it := range items {
+		var info map[string]interface{}
+		json.Unmarshal(it.Others, &info)
+		if info[KDBIndex] != float64(it.ID) {
+			t.Fatal("error db_index:", it.ID, info[KDBIndex])
+		}
+	}
+}
@@ -0,0 +1,587 @@
+package contractevent
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// 过滤表达式，在NewEvent时编译，对每个事件的info求值，如：
+//
+//	value > 1e24 and to != '0x0000000000000000000000000000000000000000'
+//	from in (0xabc..., 0xdef...) or not (tx.from matches '^0x00')
+//
+// 数字按大整数（高精度）比较，0x开头的值（地址、hash）按数值比较，因此不区分大小写，
+// 不存在的字段为null
+type exprNode interface {
+	eval(info map[string]interface{}) (interface{}, error)
+}
+
+// exprPrec 数值比较的精度，足够精确表示uint256
+const exprPrec = 512
+
+type exprTokenKind int
+
+const (
+	tkEOF exprTokenKind = iota
+	tkIdent
+	tkNumber
+	tkString
+	tkOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+	pos  int
+}
+
+func lexExpr(src string) ([]exprToken, error) {
+	var out []exprToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] == '.' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			out = append(out, exprToken{tkIdent, src[start:i], start})
+		case unicode.IsDigit(rune(c)) || (c == '.' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			start := i
+			if strings.HasPrefix(src[i:], "0x") || strings.HasPrefix(src[i:], "0X") {
+				i += 2
+				for i < len(src) && strings.ContainsRune("0123456789abcdefABCDEF", rune(src[i])) {
+					i++
+				}
+			} else {
+				for i < len(src) {
+					d := src[i]
+					if unicode.IsDigit(rune(d)) || d == '.' || d == 'e' || d == 'E' ||
+						((d == '+' || d == '-') && (src[i-1] == 'e' || src[i-1] == 'E')) {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			out = append(out, exprToken{tkNumber, src[start:i], start})
+		case c == '\'' || c == '"':
+			start := i
+			var sb strings.Builder
+			i++
+			for ; i < len(src) && src[i] != c; i++ {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				sb.WriteByte(src[i])
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			out = append(out, exprToken{tkString, sb.String(), start})
+		default:
+			if i+1 < len(src) {
+				switch op := src[i : i+2]; op {
+				case "==", "!=", ">=", "<=", "&&", "||", "=~":
+					out = append(out, exprToken{tkOp, op, i})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("><()[],!-", rune(c)) {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			out = append(out, exprToken{tkOp, string(c), i})
+			i++
+		}
+	}
+	return append(out, exprToken{kind: tkEOF, pos: len(src)}), nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+// compileExpr 编译过滤表达式，表达式为空时返回nil
+func compileExpr(src string) (exprNode, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, nil
+	}
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := exprParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tkEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return node, nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != tkEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is 判断当前token是否为指定的操作符或者关键字（不区分大小写）
+func (p *exprParser) is(values ...string) bool {
+	t := p.peek()
+	if t.kind != tkOp && t.kind != tkIdent {
+		return false
+	}
+	for _, v := range values {
+		if strings.EqualFold(t.text, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) expect(value string) error {
+	if !p.is(value) {
+		t := p.peek()
+		return fmt.Errorf("expect %q at %d, get %q", value, t.pos, t.text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.is("or", "||") {
+		p.next()
+		var right exprNode
+		right, err = p.parseAnd()
+		left = &logicNode{or: true, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	for err == nil && p.is("and", "&&") {
+		p.next()
+		var right exprNode
+		right, err = p.parseNot()
+		left = &logicNode{left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.is("not", "!") {
+		p.next()
+		node, err := p.parseNot()
+		return &notNode{node}, err
+	}
+	return p.parseCmp()
+}
+
+func (p *exprParser) parseCmp() (exprNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.is("==", "!=", ">", ">=", "<", "<="):
+		op := p.next().text
+		right, err := p.parseOperand()
+		return &cmpNode{op: op, left: left, right: right}, err
+	case p.is("in"):
+		p.next()
+		list, err := p.parseList()
+		return &inNode{value: left, list: list}, err
+	case p.is("not"):
+		p.next()
+		if err = p.expect("in"); err != nil {
+			return nil, err
+		}
+		list, err := p.parseList()
+		return &notNode{&inNode{value: left, list: list}}, err
+	case p.is("matches", "=~"):
+		p.next()
+		t := p.next()
+		if t.kind != tkString {
+			return nil, fmt.Errorf("expect regexp string at %d", t.pos)
+		}
+		re, err := regexp.Compile(t.text)
+		return &matchNode{value: left, re: re}, err
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseList() ([]exprNode, error) {
+	end := ")"
+	if p.is("[") {
+		end = "]"
+	} else if !p.is("(") {
+		t := p.peek()
+		return nil, fmt.Errorf("expect list at %d", t.pos)
+	}
+	p.next()
+	var out []exprNode
+	for !p.is(end) {
+		if len(out) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		it, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, it)
+	}
+	p.next()
+	return out, nil
+}
+
+func (p *exprParser) parseOperand() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tkIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null", "nil":
+			return literalNode{nil}, nil
+		}
+		return fieldNode(t.text), nil
+	case tkNumber:
+		if strings.HasPrefix(strings.ToLower(t.text), "0x") {
+			return literalNode{strings.ToLower(t.text)}, nil
+		}
+		if v, ok := new(big.Int).SetString(t.text, 10); ok {
+			return literalNode{v}, nil
+		}
+		v, ok := new(big.Float).SetPrec(exprPrec).SetString(t.text)
+		if !ok {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return literalNode{v}, nil
+	case tkString:
+		return literalNode{t.text}, nil
+	case tkOp:
+		// 负数，如int256类型的amount0 < -1000
+		if t.text == "-" && p.peek().kind == tkNumber && !strings.HasPrefix(strings.ToLower(p.peek().text), "0x") {
+			node, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			switch v := node.(literalNode).value.(type) {
+			case *big.Int:
+				return literalNode{v.Neg(v)}, nil
+			case *big.Float:
+				return literalNode{v.Neg(v)}, nil
+			}
+		}
+		if t.text == "(" {
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		}
+	}
+	if t.kind == tkEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type fieldNode string
+
+func (n fieldNode) eval(info map[string]interface{}) (interface{}, error) {
+	return normalizeValue(info[string(n)]), nil
+}
+
+type logicNode struct {
+	or          bool
+	left, right exprNode
+}
+
+func (n *logicNode) eval(info map[string]interface{}) (interface{}, error) {
+	l, err := evalBool(n.left, info)
+	if err != nil {
+		return nil, err
+	}
+	if l == n.or {
+		return l, nil
+	}
+	return evalBool(n.right, info)
+}
+
+type notNode struct {
+	node exprNode
+}
+
+func (n *notNode) eval(info map[string]interface{}) (interface{}, error) {
+	v, err := evalBool(n.node, info)
+	return !v, err
+}
+
+type cmpNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *cmpNode) eval(info map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(info)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(info)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equalValues(l, r), nil
+	case "!=":
+		return !equalValues(l, r), nil
+	}
+	// 不存在的字段不满足大小比较
+	if l == nil || r == nil {
+		return false, nil
+	}
+	c, err := compareValues(l, r)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	default:
+		return c <= 0, nil
+	}
+}
+
+type inNode struct {
+	value exprNode
+	list  []exprNode
+}
+
+func (n *inNode) eval(info map[string]interface{}) (interface{}, error) {
+	v, err := n.value.eval(info)
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range n.list {
+		item, err := it.eval(info)
+		if err != nil {
+			return nil, err
+		}
+		if equalValues(v, item) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type matchNode struct {
+	value exprNode
+	re    *regexp.Regexp
+}
+
+func (n *matchNode) eval(info map[string]interface{}) (interface{}, error) {
+	v, err := n.value.eval(info)
+	if err != nil || v == nil {
+		return false, err
+	}
+	switch val := v.(type) {
+	case *big.Int:
+		return n.re.MatchString(val.String()), nil
+	case *big.Float:
+		return n.re.MatchString(val.Text('f', -1)), nil
+	}
+	return n.re.MatchString(fmt.Sprint(v)), nil
+}
+
+// exprFields 表达式引用的字段
+func exprFields(node exprNode) []string {
+	switch n := node.(type) {
+	case fieldNode:
+		return []string{string(n)}
+	case *logicNode:
+		return append(exprFields(n.left), exprFields(n.right)...)
+	case *notNode:
+		return exprFields(n.node)
+	case *cmpNode:
+		return append(exprFields(n.left), exprFields(n.right)...)
+	case *inNode:
+		out := exprFields(n.value)
+		for _, it := range n.list {
+			out = append(out, exprFields(it)...)
+		}
+		return out
+	case *matchNode:
+		return exprFields(n.value)
+	}
+	return nil
+}
+
+func evalBool(node exprNode, info map[string]interface{}) (bool, error) {
+	v, err := node.eval(info)
+	if err != nil {
+		return false, err
+	}
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case nil:
+		return false, nil
+	}
+	return false, fmt.Errorf("not a boolean value:%v", v)
+}
+
+// normalizeValue 把info中的值转换为表达式的值：整数为*big.Int，小数为*big.Float，地址、hash、bytes为0x开头的字符串
+func normalizeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil, bool, string:
+		return val
+	case *big.Int:
+		if val == nil {
+			return nil
+		}
+		return val
+	case common.Address:
+		return strings.ToLower(val.Hex())
+	case common.Hash:
+		return val.Hex()
+	case []byte:
+		return hexutil.Encode(val)
+	case [32]byte:
+		return hexutil.Encode(val[:])
+	case uint64:
+		return new(big.Int).SetUint64(val)
+	case int64:
+		return big.NewInt(val)
+	}
+	// 其他类型与check一样按JSON处理
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	s := string(data)
+	if strings.HasPrefix(s, `"`) {
+		var out string
+		json.Unmarshal(data, &out)
+		return out
+	}
+	if i, ok := new(big.Int).SetString(s, 10); ok {
+		return i
+	}
+	if f, ok := new(big.Float).SetPrec(exprPrec).SetString(s); ok {
+		return f
+	}
+	return s
+}
+
+// toInt 整数、整数字符串和0x开头的字符串按整数精确比较
+func toInt(v interface{}) (*big.Int, bool) {
+	switch val := v.(type) {
+	case *big.Int:
+		return val, true
+	case *big.Float:
+		if !val.IsInt() {
+			return nil, false
+		}
+		i, acc := val.Int(nil)
+		return i, acc == big.Exact
+	case string:
+		if len(val) > 2 && (strings.HasPrefix(val, "0x") || strings.HasPrefix(val, "0X")) {
+			return new(big.Int).SetString(val[2:], 16)
+		}
+		return new(big.Int).SetString(val, 10)
+	}
+	return nil, false
+}
+
+// toNumber 数字、数字字符串和0x开头的字符串都可以作为数值比较
+func toNumber(v interface{}) (*big.Float, bool) {
+	switch val := v.(type) {
+	case *big.Float:
+		return val, true
+	case *big.Int:
+		return new(big.Float).SetPrec(exprPrec).SetInt(val), true
+	case string:
+		if len(val) > 2 && (strings.HasPrefix(val, "0x") || strings.HasPrefix(val, "0X")) {
+			i, ok := new(big.Int).SetString(val[2:], 16)
+			if !ok {
+				return nil, false
+			}
+			return new(big.Float).SetPrec(exprPrec).SetInt(i), true
+		}
+		if val == "" || !strings.ContainsRune("0123456789-.", rune(val[0])) {
+			return nil, false
+		}
+		f, ok := new(big.Float).SetPrec(exprPrec).SetString(val)
+		return f, ok
+	}
+	return nil, false
+}
+
+func equalValues(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if c, err := compareValues(a, b); err == nil {
+		return c == 0
+	}
+	return a == b
+}
+
+func compareValues(a, b interface{}) (int, error) {
+	ai, aok := toInt(a)
+	bi, bok := toInt(b)
+	if aok && bok {
+		return ai.Cmp(bi), nil
+	}
+	an, aok := toNumber(a)
+	bn, bok := toNumber(b)
+	if aok && bok {
+		return an.Cmp(bn), nil
+	}
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok {
+		return strings.Compare(as, bs), nil
+	}
+	return 0, fmt.Errorf("unable to compare %v with %v", a, b)
+}
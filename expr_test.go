@@ -0,0 +1,75 @@
+package contractevent
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestExpr(t *testing.T) {
+	value, _ := new(big.Int).SetString("2000000000000000000000000", 10)
+	// 超过浮点数精度的整数也需要精确比较
+	huge, _ := new(big.Int).SetString("1"+strings.Repeat("0", 160), 10)
+	huge.Add(huge, big.NewInt(1))
+	info := map[string]interface{}{
+		"from":          common.HexToAddress("0x99Ac8cA7087fA4A2A1FB6357269965A2014ABC35"),
+		"to":            common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		"value":         value,
+		KBlockNumber:    uint64(101),
+		KEventName:      "Transfer",
+		KTokenAmount:    "2000000",
+		KTokenDecimals:  uint8(18),
+		"tx.from":       common.HexToAddress("0x0000aaaa00000000000000000000000000000000"),
+		"receipt.empty": nil,
+		"huge":          huge,
+		"amount0":       big.NewInt(-2000),
+	}
+	cases := []struct {
+		expr string
+		hope bool
+	}{
+		{"value > 1e24", true},
+		{"value >= 2000000000000000000000000 and value < 2000000000000000000000001", true},
+		{"to != '0x0000000000000000000000000000000000000000'", true},
+		{"from == 0x99ac8ca7087fa4a2a1fb6357269965a2014abc35", true},
+		{"from == '0x99AC8CA7087FA4A2A1FB6357269965A2014ABC35'", true},
+		{"from in (0x2222222222222222222222222222222222222222, 0x99ac8ca7087fa4a2a1fb6357269965a2014abc35)", true},
+		{"to not in [0x1111111111111111111111111111111111111111]", false},
+		{"event_name == 'Transfer' && !(block_number < 100)", true},
+		{"event_name matches '^Tran' or value < 1", true},
+		{"tx.from =~ '^0x0000'", true},
+		{"token_amount > 1.5 and token_decimals == 18", true},
+		{"not_exist == null and receipt.empty == nil", true},
+		{"not_exist > 1", false},
+		{"event_name == 'Approval' or (value > 1e25)", false},
+		{"huge > 1" + strings.Repeat("0", 160), true},
+		{"huge == '" + huge.String() + "'", true},
+		{"amount0 < -1000 and amount0 == -2000", true},
+		{"amount0 > -1.5e3 or amount0 >= -1999", false},
+		{"amount0 in (-2000, 1) and value > -1", true},
+	}
+	for _, it := range cases {
+		node, err := compileExpr(it.expr)
+		if err != nil {
+			t.Fatal("fail to compile:", it.expr, err)
+		}
+		out, err := evalBool(node, info)
+		if err != nil {
+			t.Fatal("fail to eval:", it.expr, err)
+		}
+		if out != it.hope {
+			t.Error("error result:", it.expr, ",hope:", it.hope)
+		}
+	}
+	for _, it := range []string{"value >", "value = 1", "(value > 1", "from in 1", "to matches x", "'abc", "value > -", "- value > 1", "value > -0x1"} {
+		if _, err := compileExpr(it); err == nil {
+			t.Error("hope compile error:", it)
+		}
+	}
+	node, _ := compileExpr("value")
+	if _, err := evalBool(node, info); err == nil {
+		t.Error("hope not boolean error")
+	}
+}
@@ -0,0 +1,135 @@
+package contractevent
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// FactoryConf 子订阅的合约来自另一个订阅（工厂合约）的事件字段，如Uniswap的PairCreated事件的pair
+type FactoryConf struct {
+	Alias string `yaml:"alias"`
+	Field string `yaml:"field"`
+}
+
+// setupFactory 加载已发现的子合约，并在工厂订阅上注册发现新合约的hook
+func (m *Manager) setupFactory(key string, event *Event) error {
+	conf := event.conf.Factory
+	parent, ok := m.events[SubscriptionKey(event.conf.Chain, conf.Alias)]
+	if !ok {
+		log.Errorln("not found factory subscription:", key, conf.Alias)
+		return fmt.Errorf("not found factory subscription:%s", conf.Alias)
+	}
+	if event.conf.Mode == ModeStream {
+		log.Errorln("factory subscription not support stream mode:", key)
+		return fmt.Errorf("factory subscription not support stream mode:%s", key)
+	}
+	err := parent.conf.Transform.protect(conf.Field)
+	if err != nil {
+		log.Errorln("error transform of factory subscription:", key, conf.Alias, err)
+		return err
+	}
+	event.dynamic = true
+	records, err := ListContractRecords(m.db, key)
+	if err != nil {
+		return err
+	}
+	for _, it := range records {
+		addr := common.HexToAddress(it.Address)
+		if !it.Pending {
+			event.AddContract(addr)
+			continue
+		}
+		// 上次没有补齐的合约从中断的位置继续
+		start := it.BlockID
+		if it.SyncedBlock >= start {
+			start = it.SyncedBlock + 1
+		}
+		event.Discover(addr, start)
+	}
+	log.Infoln("load factory contracts:", key, conf.Alias, len(records))
+	parent.hooks = append(parent.hooks, m.discover(key, event, conf.Field))
+	return nil
+}
+
+// discover 工厂订阅收到事件后，把字段中的合约地址加入子订阅，并从合约创建的区块开始补齐
+func (m *Manager) discover(key string, event *Event, field string) EventCallback {
+	return func(alias string, info map[string]interface{}) error {
+		if removed, _ := info[KRemoved].(bool); removed {
+			return nil
+		}
+		var addr common.Address
+		switch v := info[field].(type) {
+		case common.Address:
+			addr = v
+		case string:
+			if !common.IsHexAddress(v) {
+				return nil
+			}
+			addr = common.HexToAddress(v)
+		default:
+			log.Debugln("not found contract field of factory event:", alias, field)
+			return nil
+		}
+		bn, _ := info[KBlockNumber].(uint64)
+		created, err := AddContractRecord(m.db, key, addr.Hex(), bn)
+		if err != nil {
+			log.Errorln("fail to add contract record:", key, addr.Hex(), err)
+			return err
+		}
+		if !created {
+			return nil
+		}
+		event.Discover(addr, bn)
+		log.Infoln("discover new contract:", key, addr.Hex(), bn)
+		return nil
+	}
+}
+
+// catchUp 把新发现的合约加入子订阅，并补齐合约创建区块到当前进度之间的事件，
+// 补齐的进度记录在ContractRecord中，失败或者重启后从未完成的区块继续
+func (m *Manager) catchUp(alias string, event *Event, step *blockStep) bool {
+	discovered := event.takeDiscovered()
+	if len(discovered) == 0 {
+		return true
+	}
+	cur, err := GetBlockRecord(m.db, alias)
+	if err != nil {
+		for addr, bn := range discovered {
+			event.Discover(addr, bn)
+		}
+		return false
+	}
+	ok := true
+	for addr, bn := range discovered {
+		event.AddContract(addr)
+		if bn > cur {
+			// 创建区块在当前进度之后，不需要补齐
+			err = UpdateContractRecord(m.db, alias, addr.Hex(), cur, false)
+			if err != nil {
+				log.Errorln("fail to update contract record:", alias, addr.Hex(), err)
+			}
+			continue
+		}
+		for bn <= cur {
+			end := step.Last(bn)
+			if end > cur {
+				end = cur
+			}
+			err = event.catchUp(addr, bn, end)
+			if err == nil {
+				err = UpdateContractRecord(m.db, alias, addr.Hex(), end, end < cur)
+			}
+			if err != nil {
+				log.Errorln("fail to catch up contract:", alias, addr.Hex(), bn, end, err)
+				event.Discover(addr, bn)
+				ok = false
+				break
+			}
+			log.Infoln("catch up contract:", alias, addr.Hex(), bn, end)
+			bn = end + 1
+		}
+	}
+	return ok
+}
@@ -0,0 +1,153 @@
+package contractevent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultFetchWait = 100
+
+// logFetcher 合并同一条链上区块进度相同的订阅的eth_getLogs请求，
+// 第一个请求等待一小段时间，期间起始区块相同的订阅会加入同一个批次，
+// 批次使用所有订阅的合约地址和topic0的并集查询，结果再按各自的query分发。
+// 只有同时限制了合约地址和topic0的订阅才合并，否则并集会变成查询所有日志
+type logFetcher struct {
+	client  LogSource
+	wait    time.Duration
+	mu      sync.Mutex
+	members int
+	pending map[uint64]*fetchBatch
+}
+
+type fetchBatch struct {
+	to     uint64
+	events []*Event
+	// 所有订阅都加入批次后不再等待
+	full chan struct{}
+	done chan struct{}
+	logs []types.Log
+	err  error
+}
+
+func newLogFetcher(client LogSource, wait int64) *logFetcher {
+	if wait <= 0 {
+		wait = defaultFetchWait
+	}
+	return &logFetcher{
+		client:  client,
+		wait:    time.Duration(wait) * time.Millisecond,
+		pending: make(map[uint64]*fetchBatch),
+	}
+}
+
+// add 登记共享查询的订阅
+func (f *logFetcher) add(e *Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e.shared = f
+	f.members++
+}
+
+// mergeable 没有限制合约地址或者topic0的订阅单独查询
+func mergeable(query ethereum.FilterQuery) bool {
+	return len(query.Addresses) > 0 && len(query.Topics) > 0 && len(query.Topics[0]) > 0
+}
+
+// fetch 返回属于该订阅的日志，批次的结束区块可能小于to，返回实际的结束区块以及批次的日志总数
+func (f *logFetcher) fetch(e *Event, from, to uint64) ([]types.Log, uint64, int, error) {
+	if !mergeable(e.Query()) {
+		query := e.Query()
+		query.FromBlock = newBig(from)
+		query.ToBlock = newBig(to)
+		logs, err := f.client.FilterLogs(context.Background(), query)
+		return logs, to, len(logs), err
+	}
+	f.mu.Lock()
+	b, ok := f.pending[from]
+	if ok {
+		b.events = append(b.events, e)
+		if to < b.to {
+			b.to = to
+		}
+		if len(b.events) == f.members {
+			close(b.full)
+		}
+		f.mu.Unlock()
+	} else {
+		b = &fetchBatch{to: to, events: []*Event{e}, full: make(chan struct{}), done: make(chan struct{})}
+		f.pending[from] = b
+		members := f.members
+		f.mu.Unlock()
+		if members > 1 {
+			select {
+			case <-time.After(f.wait):
+			case <-b.full:
+			}
+		}
+		f.mu.Lock()
+		delete(f.pending, from)
+		f.mu.Unlock()
+		f.run(from, b)
+	}
+	<-b.done
+	if b.err != nil {
+		return nil, 0, 0, b.err
+	}
+	var out []types.Log
+	for _, it := range b.logs {
+		if e.match(it) {
+			out = append(out, it)
+		}
+	}
+	return out, b.to, len(b.logs), nil
+}
+
+func (f *logFetcher) run(from uint64, b *fetchBatch) {
+	defer close(b.done)
+	query := mergeQuery(b.events)
+	query.FromBlock = newBig(from)
+	query.ToBlock = newBig(b.to)
+	b.logs, b.err = f.client.FilterLogs(context.Background(), query)
+	if b.err != nil {
+		log.Errorln("fail to FilterLogs of shared query:", len(b.events), from, b.to, b.err)
+		return
+	}
+	if len(b.events) > 1 {
+		log.Debugln("shared FilterLogs:", len(b.events), from, b.to, len(b.logs))
+	}
+}
+
+// mergeQuery 合并合约地址和topic0
+func mergeQuery(events []*Event) ethereum.FilterQuery {
+	var out ethereum.FilterQuery
+	addrs := make(map[common.Address]bool)
+	topics := make(map[common.Hash]bool)
+	for _, e := range events {
+		query := e.Query()
+		for _, it := range query.Addresses {
+			if !addrs[it] {
+				addrs[it] = true
+				out.Addresses = append(out.Addresses, it)
+			}
+		}
+		if len(query.Topics) == 0 {
+			continue
+		}
+		for _, it := range query.Topics[0] {
+			if !topics[it] {
+				topics[it] = true
+				if len(out.Topics) == 0 {
+					out.Topics = append(out.Topics, nil)
+				}
+				out.Topics[0] = append(out.Topics[0], it)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,43 @@
+package contractevent
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestMergeQuery(t *testing.T) {
+	a1 := common.HexToAddress("0x01")
+	a2 := common.HexToAddress("0x02")
+	t1 := common.HexToHash("0x11")
+	t2 := common.HexToHash("0x22")
+	e1 := &Event{query: ethereum.FilterQuery{Addresses: []common.Address{a1}, Topics: [][]common.Hash{{t1}}}}
+	e2 := &Event{query: ethereum.FilterQuery{Addresses: []common.Address{a1, a2}, Topics: [][]common.Hash{{t2}, {}, {t1}}}}
+
+	q := mergeQuery([]*Event{e1, e2})
+	if len(q.Addresses) != 2 || len(q.Topics) != 1 || len(q.Topics[0]) != 2 {
+		t.Fatal("error merged query:", q)
+	}
+
+	l1 := types.Log{Address: a1, Topics: []common.Hash{t1}}
+	l2 := types.Log{Address: a2, Topics: []common.Hash{t2, t2, t1}}
+	l3 := types.Log{Address: a2, Topics: []common.Hash{t2, t2, t2}}
+	if !e1.match(l1) || e2.match(l1) {
+		t.Fatal("error match of log1")
+	}
+	if e1.match(l2) || !e2.match(l2) {
+		t.Fatal("error match of log2")
+	}
+	if e2.match(l3) {
+		t.Fatal("error match of log3")
+	}
+
+	// 不限制合约地址或topic0的订阅单独查询
+	e3 := &Event{query: ethereum.FilterQuery{Topics: [][]common.Hash{{t1}}}}
+	e4 := &Event{query: ethereum.FilterQuery{Addresses: []common.Address{a1}}}
+	if !mergeable(e1.Query()) || mergeable(e3.Query()) || mergeable(e4.Query()) {
+		t.Fatal("error mergeable")
+	}
+}
@@ -0,0 +1,40 @@
+package contractevent
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const defaultHeaderCacheSize = 4096
+
+type headerReader interface {
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+}
+
+// headerCache 缓存区块时间，按区块hash索引，链重组后不会拿到错误的数据
+type headerCache struct {
+	reader headerReader
+	times  *lru.Cache[common.Hash, uint64]
+}
+
+func newHeaderCache(reader headerReader, size int) *headerCache {
+	if size <= 0 {
+		size = defaultHeaderCacheSize
+	}
+	return &headerCache{reader: reader, times: lru.NewCache[common.Hash, uint64](size)}
+}
+
+func (c *headerCache) BlockTime(ctx context.Context, hash common.Hash) (uint64, error) {
+	if t, ok := c.times.Get(hash); ok {
+		return t, nil
+	}
+	header, err := c.reader.HeaderByHash(ctx, hash)
+	if err != nil {
+		return 0, err
+	}
+	c.times.Add(hash, header.Time)
+	return header.Time, nil
+}
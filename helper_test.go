@@ -0,0 +1,28 @@
+package contractevent
+
+import (
+	"testing"
+)
+
+// loadTestSource 加载ERC20 Transfer/Approval的测试数据（区块100-102）
+func loadTestSource(t *testing.T) *MemorySource {
+	t.Helper()
+	source, err := LoadMemorySource("testdata/erc20_transfer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+// newTestEvent 创建订阅，处理后的事件追加到events
+func newTestEvent(t *testing.T, sub SubscriptionConf, source LogSource, events *[]map[string]interface{}) *Event {
+	t.Helper()
+	event, err := NewEvent(sub, source, func(alias string, info map[string]interface{}) error {
+		*events = append(*events, info)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
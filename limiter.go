@@ -0,0 +1,98 @@
+package contractevent
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter 令牌桶限流，rate为每秒产生的令牌数，不同方法消耗的令牌数可以不同，
+// rate为0时不限流，只在节点返回429时暂停
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	until  time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(rate)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait 等待直到有足够的令牌，cost大于burst时按burst计算
+func (l *rateLimiter) Wait(ctx context.Context, cost float64) error {
+	if cost > l.burst {
+		cost = l.burst
+	}
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		var wait time.Duration
+		switch {
+		case now.Before(l.until):
+			wait = l.until.Sub(now)
+		case l.rate <= 0 || l.tokens >= cost:
+			l.tokens -= cost
+			l.mu.Unlock()
+			return nil
+		default:
+			wait = time.Duration((cost - l.tokens) / l.rate * float64(time.Second))
+		}
+		l.mu.Unlock()
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Pause 节点返回429时暂停发送请求
+func (l *rateLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.until) {
+		l.until = until
+		l.tokens = 0
+	}
+}
+
+// retryAfterTransport 解析429响应的Retry-After，通知限流器暂停
+type retryAfterTransport struct {
+	base    http.RoundTripper
+	onLimit func(d time.Duration)
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		return resp, err
+	}
+	t.onLimit(parseRetryAfter(resp.Header.Get("Retry-After")))
+	return resp, err
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if sec, err := strconv.Atoi(value); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return time.Second
+}
@@ -0,0 +1,33 @@
+package contractevent
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(100, 2)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		err := l.Wait(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 2个令牌用完后，剩下的2次请求需要等待约20ms
+	if cost := time.Since(start); cost < 15*time.Millisecond {
+		t.Fatal("limiter not work:", cost)
+	}
+
+	l.Pause(50 * time.Millisecond)
+	start = time.Now()
+	l.Wait(context.Background(), 1)
+	if cost := time.Since(start); cost < 40*time.Millisecond {
+		t.Fatal("pause not work:", cost)
+	}
+
+	if parseRetryAfter("3") != 3*time.Second {
+		t.Fatal("error Retry-After")
+	}
+}
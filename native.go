@@ -0,0 +1,279 @@
+package contractevent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+// 原生币转账的info字段，topic固定为TopicNative，event_name为Transfer
+const (
+	TopicNative     = "native"
+	KFrom           = "from"
+	KTo             = "to"
+	KValue          = "value"
+	KInternal       = "internal"
+	KCallType       = "call_type"
+	nativeEventName = "Transfer"
+)
+
+type blockSource interface {
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+}
+
+type rpcCaller interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+}
+
+// callFrame debug_traceBlockByNumber的callTracer返回的调用
+type callFrame struct {
+	Type  string          `json:"type"`
+	From  common.Address  `json:"from"`
+	To    *common.Address `json:"to"`
+	Value *hexutil.Big    `json:"value"`
+	Error string          `json:"error"`
+	Calls []callFrame     `json:"calls"`
+}
+
+type txTrace struct {
+	Result *callFrame `json:"result"`
+	Error  string     `json:"error"`
+}
+
+// rpcBlock eth_getBlockByNumber返回的区块，hash直接使用节点返回的值
+type rpcBlock struct {
+	Number       hexutil.Uint64 `json:"number"`
+	Hash         common.Hash    `json:"hash"`
+	Timestamp    hexutil.Uint64 `json:"timestamp"`
+	Transactions []rpcBlockTx   `json:"transactions"`
+}
+
+// rpcBlockTx 只解析需要的字段，L2特有的交易类型（如OP的deposit、Arbitrum的内部交易）也能解析，
+// from由节点返回，不需要验证签名
+type rpcBlockTx struct {
+	Hash  common.Hash     `json:"hash"`
+	From  common.Address  `json:"from"`
+	To    *common.Address `json:"to"`
+	Value *hexutil.Big    `json:"value"`
+}
+
+type nativeTransfer struct {
+	tx       common.Hash
+	index    uint
+	from     common.Address
+	to       common.Address
+	value    *big.Int
+	callType string
+}
+
+// nativeScanner 扫描区块中的交易，找出与钱包地址相关的原生币转账，
+// 开启Trace时通过debug_traceBlockByNumber获取合约内部的转账
+type nativeScanner struct {
+	caller  rpcCaller
+	wallets map[common.Address]bool
+	trace   bool
+	status  *enricher
+}
+
+func newNativeScanner(conf SubscriptionConf, client LogSource) (*nativeScanner, error) {
+	if conf.Mode == ModeStream || conf.Ingest == IngestReceipts {
+		return nil, fmt.Errorf("native subscription only support poll mode and logs ingest:%s", conf.Alias)
+	}
+	out := nativeScanner{wallets: make(map[common.Address]bool), trace: conf.Trace}
+	for _, it := range conf.Wallets {
+		if !common.IsHexAddress(it) {
+			return nil, fmt.Errorf("invalid wallet address:%s", it)
+		}
+		out.wallets[common.HexToAddress(it)] = true
+	}
+	switch c := client.(type) {
+	case rpcCaller:
+		out.caller = c
+	case rpcSource:
+		out.caller = c.Client()
+	default:
+		log.Errorln("the log source not support get block:", conf.Alias)
+		return nil, errNotSupported
+	}
+	// 没有trace时通过收据判断交易是否成功
+	out.status, _ = newEnricher(client, []string{EnrichReceipt})
+	return &out, nil
+}
+
+// match 没有配置钱包地址时匹配所有转账
+func (s *nativeScanner) match(it nativeTransfer) bool {
+	if it.value == nil || it.value.Sign() <= 0 {
+		return false
+	}
+	return len(s.wallets) == 0 || s.wallets[it.from] || s.wallets[it.to]
+}
+
+func (s *nativeScanner) run(e *Event, start, end uint64) (uint64, int, error) {
+	var total int
+	for n := start; n <= end; n++ {
+		block, err := s.block(n)
+		if err != nil {
+			log.Errorln("fail to get block:", e.conf.Alias, n, err)
+			return 0, 0, err
+		}
+		var transfers []nativeTransfer
+		if s.trace {
+			transfers, err = s.traceTransfers(block)
+			if isMethodNotFound(err) {
+				log.Warnln("node not support debug_traceBlockByNumber, only top-level transfers:", e.conf.Alias, err)
+				s.trace = false
+			}
+		}
+		if !s.trace {
+			transfers, err = s.txTransfers(block)
+		}
+		if err != nil {
+			log.Errorln("fail to get native transfers:", e.conf.Alias, n, err)
+			return 0, 0, err
+		}
+		if e.enrich != nil {
+			hashes := make([]common.Hash, 0, len(transfers))
+			for _, it := range transfers {
+				hashes = append(hashes, it.tx)
+			}
+			err = e.enrich.prefetch(hashes)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		for _, it := range transfers {
+			_, err = s.process(e, block, it)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		total += len(transfers)
+	}
+	return end, total, nil
+}
+
+// block 获取区块和交易，节点还没有该区块时返回错误
+func (s *nativeScanner) block(n uint64) (*rpcBlock, error) {
+	var out *rpcBlock
+	err := s.caller.CallContext(context.Background(), &out, "eth_getBlockByNumber", hexutil.EncodeUint64(n), true)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil || out.Hash == (common.Hash{}) {
+		return nil, fmt.Errorf("not found block:%d", n)
+	}
+	return out, nil
+}
+
+// txTransfers 交易本身的转账，通过收据过滤失败的交易
+func (s *nativeScanner) txTransfers(block *rpcBlock) ([]nativeTransfer, error) {
+	var out []nativeTransfer
+	var hashes []common.Hash
+	for _, tx := range block.Transactions {
+		if tx.To == nil || tx.Value == nil {
+			continue
+		}
+		it := nativeTransfer{tx: tx.Hash, from: tx.From, to: *tx.To, value: (*big.Int)(tx.Value), callType: "CALL"}
+		if s.match(it) {
+			out = append(out, it)
+			hashes = append(hashes, it.tx)
+		}
+	}
+	if len(out) == 0 || s.status == nil {
+		return out, nil
+	}
+	err := s.status.prefetch(hashes)
+	if err != nil {
+		return nil, err
+	}
+	var success []nativeTransfer
+	for _, it := range out {
+		detail, _ := s.status.cache.Get(it.tx)
+		if detail.receipt != nil && uint64(detail.receipt.Status) == types.ReceiptStatusSuccessful {
+			success = append(success, it)
+		}
+	}
+	return success, nil
+}
+
+// traceTransfers 通过callTracer获取所有的转账，包括合约内部的转账，
+// 交易本身的转账log_index为0，内部转账按调用顺序从1开始
+func (s *nativeScanner) traceTransfers(block *rpcBlock) ([]nativeTransfer, error) {
+	var traces []txTrace
+	err := s.caller.CallContext(context.Background(), &traces, "debug_traceBlockByNumber",
+		hexutil.EncodeUint64(uint64(block.Number)), map[string]interface{}{"tracer": "callTracer"})
+	if err != nil {
+		return nil, err
+	}
+	txs := block.Transactions
+	if len(traces) != len(txs) {
+		return nil, fmt.Errorf("trace count mismatch, block:%d traces:%d txs:%d", block.Number, len(traces), len(txs))
+	}
+	var out []nativeTransfer
+	for i, it := range traces {
+		if it.Result == nil || it.Result.Error != "" {
+			continue
+		}
+		var index uint
+		var walk func(frame *callFrame)
+		walk = func(frame *callFrame) {
+			if frame.Error != "" {
+				return
+			}
+			// DELEGATECALL的value是调用方的value，并没有转账
+			if frame.To != nil && frame.Value != nil && frame.Type != "DELEGATECALL" && frame.Type != "STATICCALL" {
+				t := nativeTransfer{tx: txs[i].Hash, index: index, from: frame.From, to: *frame.To,
+					value: (*big.Int)(frame.Value), callType: frame.Type}
+				if s.match(t) {
+					out = append(out, t)
+				}
+			}
+			for j := range frame.Calls {
+				index++
+				walk(&frame.Calls[j])
+			}
+		}
+		walk(it.Result)
+	}
+	return out, nil
+}
+
+func (s *nativeScanner) process(e *Event, block *rpcBlock, it nativeTransfer) (map[string]interface{}, error) {
+	info := make(map[string]interface{})
+	info[KAlias] = e.conf.Alias
+	info[KChainID] = e.chainID
+	info[KBlock] = block.Hash.Hex()
+	info[KBlockNumber] = uint64(block.Number)
+	info[KBlockTime] = uint64(block.Timestamp)
+	info[KTX] = it.tx.Hex()
+	info[KLogIndex] = it.index
+	info[KTopic] = TopicNative
+	info[KEventName] = nativeEventName
+	info[KFrom] = it.from
+	info[KTo] = it.to
+	info[KValue] = it.value
+	info[KInternal] = it.index > 0
+	info[KCallType] = it.callType
+	if e.enrich != nil {
+		err := e.enrich.enrich(info, it.tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e.emit(info)
+}
+
+func isMethodNotFound(err error) bool {
+	if errors.Is(err, errNotSupported) {
+		return true
+	}
+	var rpcErr rpc.Error
+	return errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32601
+}
@@ -0,0 +1,77 @@
+package contractevent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// blockNode 模拟节点返回区块和收据，failed中的交易执行失败
+type blockNode struct {
+	*MemorySource
+	block  string
+	failed map[common.Hash]bool
+}
+
+func (s *blockNode) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	switch method {
+	case "eth_getBlockByNumber":
+		return json.Unmarshal([]byte(s.block), result)
+	case "debug_traceBlockByNumber":
+		return codeError{-32601, "the method does not exist"}
+	}
+	return fmt.Errorf("unexpected method:%s", method)
+}
+
+func (s *blockNode) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	for i, it := range b {
+		status := "0x1"
+		if s.failed[it.Args[0].(common.Hash)] {
+			status = "0x0"
+		}
+		b[i].Error = json.Unmarshal([]byte(`{"gasUsed":"0x5208","status":"`+status+`"}`), it.Result)
+	}
+	return nil
+}
+
+func TestNativeTransfers(t *testing.T) {
+	wallet := "0x1111111111111111111111111111111111111111"
+	// 第一个交易为OP的deposit交易（0x7e），go-ethereum的types.Transaction不能解析
+	node := &blockNode{MemorySource: NewMemorySource(10), failed: map[common.Hash]bool{common.HexToHash("0xb2"): true}}
+	node.block = `{"number":"0x64","hash":"0x00000000000000000000000000000000000000000000000000000000000000aa","timestamp":"0x6553f100",
+	"transactions":[
+		{"type":"0x7e","hash":"` + common.HexToHash("0xb1").Hex() + `","from":"0x4200000000000000000000000000000000000015","to":"` + wallet + `","value":"0xde0b6b3a7640000","sourceHash":"` + common.HexToHash("0x01").Hex() + `","mint":"0x0"},
+		{"type":"0x2","hash":"` + common.HexToHash("0xb2").Hex() + `","from":"` + wallet + `","to":"0x2222222222222222222222222222222222222222","value":"0x1"},
+		{"type":"0x2","hash":"` + common.HexToHash("0xb3").Hex() + `","from":"0x3333333333333333333333333333333333333333","to":"0x2222222222222222222222222222222222222222","value":"0x1"},
+		{"type":"0x0","hash":"` + common.HexToHash("0xb4").Hex() + `","from":"` + wallet + `","to":null,"value":"0x1"}
+	]}`
+	sub := SubscriptionConf{Alias: "wallet", Type: TypeNative, Wallets: []string{wallet}, Trace: true}
+	var events []map[string]interface{}
+	event := newTestEvent(t, sub, node, &events)
+	err := event.Run(100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 失败的交易、无关的地址和创建合约的交易都不记录
+	if len(events) != 1 {
+		t.Fatal("error events:", events)
+	}
+	info := events[0]
+	value, _ := new(big.Int).SetString("1000000000000000000", 10)
+	if info[KTX] != common.HexToHash("0xb1").Hex() || info[KTo] != common.HexToAddress(wallet) ||
+		info[KValue].(*big.Int).Cmp(value) != 0 || info[KBlockTime] != uint64(0x6553f100) ||
+		info[KBlock] != common.HexToHash("0xaa").Hex() || info[KBlockNumber] != uint64(100) {
+		t.Fatal("error info:", info)
+	}
+
+	// 节点还没有该区块时返回错误，之后重试
+	node.block = "null"
+	if err = event.Run(101, 101); err == nil {
+		t.Fatal("hope error of missing block")
+	}
+}
@@ -2,6 +2,7 @@ package contractevent
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 
@@ -15,6 +16,16 @@ type NotifyTask struct {
 	webHook string
 }
 
+const NotifyTypeReorg = "reorg"
+
+type ReorgNotify struct {
+	Type      string                   `json:"type"`
+	Alias     string                   `json:"alias"`
+	FromBlock uint64                   `json:"from_block"`
+	ToBlock   uint64                   `json:"to_block"`
+	Removed   []map[string]interface{} `json:"removed,omitempty"`
+}
+
 func NewNotifyTask(db *gorm.DB, alias, webHook string) *NotifyTask {
 	SetNotifyRecord(db, alias, 0)
 	return &NotifyTask{alias: alias, db: db, webHook: webHook}
@@ -32,16 +43,10 @@ func (t *NotifyTask) Run(limit uint) error {
 	}
 	last := id
 	for _, it := range items {
-		resp, err := http.DefaultClient.Post(t.webHook, "application/json", bytes.NewReader(it.Others))
+		err = t.post(it.Others)
 		if err != nil {
-			log.Errorln("fail to Post:", err)
 			return err
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			log.Errorln("notify, get wrong code(hope 200 OK):", resp.Status)
-			return fmt.Errorf("error response code:%s", resp.Status)
-		}
 		if it.ID > last {
 			last = it.ID
 		}
@@ -49,3 +54,34 @@ func (t *NotifyTask) Run(limit uint) error {
 	}
 	return SetNotifyRecord(t.db, t.alias, last)
 }
+
+// NotifyReorg 通知webhook链发生了重组，[from,to]区间的事件需要重新处理，removed为已删除的事件
+func (t *NotifyTask) NotifyReorg(from, to uint64, removed []map[string]interface{}) error {
+	data, _ := json.Marshal(ReorgNotify{
+		Type:      NotifyTypeReorg,
+		Alias:     t.alias,
+		FromBlock: from,
+		ToBlock:   to,
+		Removed:   removed,
+	})
+	err := t.post(data)
+	if err != nil {
+		return err
+	}
+	log.Infoln("notify reorg success:", t.alias, from, to, len(removed))
+	return nil
+}
+
+func (t *NotifyTask) post(data []byte) error {
+	resp, err := http.DefaultClient.Post(t.webHook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Errorln("fail to Post:", err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Errorln("notify, get wrong code(hope 200 OK):", resp.Status)
+		return fmt.Errorf("error response code:%s", resp.Status)
+	}
+	return nil
+}
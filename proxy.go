@@ -0,0 +1,211 @@
+package contractevent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// eip1967Slot bytes32(uint256(keccak256('eip1967.proxy.implementation')) - 1)
+	eip1967Slot = common.HexToHash("0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc")
+	// eip1822Slot keccak256("PROXIABLE")
+	eip1822Slot = common.HexToHash("0xc5f16f0fcc639fa48a6947836d9850f504798523bf8c9a3a87d5876cf622bcf7")
+	// upgradedTopic Upgraded(address indexed implementation)
+	upgradedTopic = common.HexToHash("0xbc7cd75a20ee27fd9adebab32041f755214dbc6bffa90cc0225b39da2e5c2d3b")
+)
+
+type storageReader interface {
+	StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error)
+}
+
+// proxyResolver 可升级合约（代理合约）的ABI，通过EIP-1967/EIP-1822的slot获取实现合约的地址，
+// 从ABIDir中加载实现合约的ABI（文件名为<地址>.json），收到Upgraded事件后从该日志开始使用新的ABI
+type proxyResolver struct {
+	reader storageReader
+	dir    string
+	base   abi.ABI
+	events map[common.Hash]bool
+	mu     sync.Mutex
+	impls  map[common.Address][]implRange
+	abis   map[common.Address]abi.ABI
+}
+
+// implRange 从(block,index)位置的日志开始使用的实现合约，直到下一个区间。
+// 日志按区块顺序处理，两个区间之间的升级都会通过Upgraded事件记录
+type implRange struct {
+	block uint64
+	index uint
+	impl  common.Address
+}
+
+func (r implRange) before(block uint64, index uint) bool {
+	return r.block < block || (r.block == block && r.index <= index)
+}
+
+func newProxyResolver(conf SubscriptionConf, client LogSource, base abi.ABI) (*proxyResolver, error) {
+	reader, ok := client.(storageReader)
+	if !ok {
+		return nil, errNotSupported
+	}
+	out := proxyResolver{
+		reader: reader,
+		dir:    conf.ABIDir,
+		base:   base,
+		impls:  make(map[common.Address][]implRange),
+		abis:   make(map[common.Address]abi.ABI),
+		events: make(map[common.Hash]bool),
+	}
+	for _, name := range conf.Events() {
+		if ev, ok := base.Events[name]; ok {
+			out.events[ev.ID] = true
+		}
+	}
+	return &out, nil
+}
+
+// resolve 返回解析该日志使用的ABI
+func (p *proxyResolver) resolve(vLog types.Log) abi.ABI {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if vLog.Topics[0] == upgradedTopic && len(vLog.Topics) > 1 && !vLog.Removed {
+		impl := common.BytesToAddress(vLog.Topics[1].Bytes())
+		if old, ok := p.lookup(vLog.Address, vLog.BlockNumber, vLog.Index); !ok || old != impl {
+			log.Infoln("proxy upgraded:", vLog.Address.Hex(), old.Hex(), impl.Hex(), vLog.BlockNumber)
+		}
+		p.insert(vLog.Address, implRange{block: vLog.BlockNumber, index: vLog.Index, impl: impl})
+		return p.abiOf(impl)
+	}
+	if vLog.Topics[0] == upgradedTopic && vLog.Removed {
+		p.remove(vLog.Address, vLog.BlockNumber, vLog.Index)
+	}
+	impl, ok := p.lookup(vLog.Address, vLog.BlockNumber, vLog.Index)
+	if !ok {
+		impl = p.implementation(vLog.Address, vLog.BlockNumber)
+		p.insert(vLog.Address, implRange{block: vLog.BlockNumber, impl: impl})
+	}
+	return p.abiOf(impl)
+}
+
+// lookup 返回日志所在位置之前最近的区间的实现合约
+func (p *proxyResolver) lookup(proxy common.Address, block uint64, index uint) (common.Address, bool) {
+	ranges := p.impls[proxy]
+	for i := len(ranges) - 1; i >= 0; i-- {
+		if ranges[i].before(block, index) {
+			return ranges[i].impl, true
+		}
+	}
+	return common.Address{}, false
+}
+
+// insert 按位置顺序插入区间，相同位置的区间被替换
+func (p *proxyResolver) insert(proxy common.Address, r implRange) {
+	ranges := p.impls[proxy]
+	i := sort.Search(len(ranges), func(i int) bool {
+		return !ranges[i].before(r.block, r.index)
+	})
+	if i > 0 && ranges[i-1].block == r.block && ranges[i-1].index == r.index {
+		ranges[i-1] = r
+		return
+	}
+	p.impls[proxy] = slices.Insert(ranges, i, r)
+}
+
+// remove 删除被回滚的Upgraded事件对应的区间
+func (p *proxyResolver) remove(proxy common.Address, block uint64, index uint) {
+	p.impls[proxy] = slices.DeleteFunc(p.impls[proxy], func(r implRange) bool {
+		return r.block == block && r.index == index
+	})
+}
+
+// rewind 链重组后删除回滚区块之后的区间，重新处理时再从Upgraded事件或者状态中获取
+func (p *proxyResolver) rewind(block uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for proxy, ranges := range p.impls {
+		i := sort.Search(len(ranges), func(i int) bool {
+			return ranges[i].block > block
+		})
+		p.impls[proxy] = ranges[:i]
+	}
+}
+
+// hidden 订阅的不是Upgraded事件时，Upgraded只用于切换ABI，不通知callback
+func (p *proxyResolver) hidden(vLog types.Log) bool {
+	return vLog.Topics[0] == upgradedTopic && len(p.events) > 0 && !p.events[upgradedTopic]
+}
+
+// implementation 读取区块执行前的实现合约地址，同一区块中Upgraded之前的日志使用旧的ABI，
+// 节点不支持历史状态时使用最新的状态
+func (p *proxyResolver) implementation(proxy common.Address, block uint64) common.Address {
+	var number *big.Int
+	if block > 0 {
+		number = newBig(block - 1)
+	}
+	for _, slot := range []common.Hash{eip1967Slot, eip1822Slot} {
+		value, err := p.reader.StorageAt(context.Background(), proxy, slot, number)
+		if err != nil && number != nil {
+			log.Warnln("fail to get history storage, try latest:", proxy.Hex(), block, err)
+			value, err = p.reader.StorageAt(context.Background(), proxy, slot, nil)
+		}
+		if err != nil {
+			log.Warnln("fail to get implementation of proxy:", proxy.Hex(), err)
+			return common.Address{}
+		}
+		if impl := common.BytesToAddress(value); impl != (common.Address{}) {
+			log.Infoln("proxy implementation:", proxy.Hex(), impl.Hex(), block)
+			return impl
+		}
+	}
+	log.Warnln("not found implementation of proxy:", proxy.Hex())
+	return common.Address{}
+}
+
+// abiOf 加载实现合约的ABI，并补充ABIFile中有、实现合约中没有的事件，找不到时使用ABIFile
+func (p *proxyResolver) abiOf(impl common.Address) abi.ABI {
+	if impl == (common.Address{}) {
+		return p.base
+	}
+	if out, ok := p.abis[impl]; ok {
+		return out
+	}
+	out, err := p.load(impl)
+	if err != nil {
+		log.Warnln("fail to load abi of implementation, use abi_file:", impl.Hex(), err)
+		out = p.base
+	} else {
+		for key, ev := range p.base.Events {
+			if _, ok := out.Events[key]; !ok {
+				out.Events[key] = ev
+			}
+		}
+	}
+	p.abis[impl] = out
+	return out
+}
+
+func (p *proxyResolver) load(impl common.Address) (abi.ABI, error) {
+	if p.dir == "" {
+		return abi.ABI{}, fmt.Errorf("abi_dir is empty")
+	}
+	var err error
+	for _, name := range []string{impl.Hex(), strings.ToLower(impl.Hex())} {
+		var data []byte
+		data, err = os.ReadFile(filepath.Join(p.dir, name+".json"))
+		if err == nil {
+			return parseABI(data)
+		}
+	}
+	return abi.ABI{}, err
+}
@@ -0,0 +1,64 @@
+package contractevent
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type slotReader struct {
+	impl  common.Address
+	calls int
+}
+
+func (r *slotReader) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	r.calls++
+	return common.LeftPadBytes(r.impl.Bytes(), 32), nil
+}
+
+func TestProxyRanges(t *testing.T) {
+	reader := &slotReader{impl: common.HexToAddress("0x01")}
+	p := &proxyResolver{reader: reader, impls: make(map[common.Address][]implRange), abis: make(map[common.Address]abi.ABI)}
+	proxy := common.HexToAddress("0xaa")
+	newImpl := common.HexToAddress("0x02")
+	upgraded := types.Log{Address: proxy, BlockNumber: 20, Index: 3,
+		Topics: []common.Hash{upgradedTopic, common.BytesToHash(newImpl.Bytes())}}
+
+	p.resolve(types.Log{Address: proxy, BlockNumber: 10, Topics: []common.Hash{{}}})
+	p.resolve(upgraded)
+	cases := []struct {
+		block uint64
+		index uint
+		hope  common.Address
+	}{
+		{10, 0, reader.impl},
+		{20, 2, reader.impl},
+		{20, 3, newImpl},
+		{30, 0, newImpl},
+	}
+	for _, it := range cases {
+		impl, ok := p.lookup(proxy, it.block, it.index)
+		if !ok || impl != it.hope {
+			t.Error("error implementation:", it.block, it.index, impl.Hex())
+		}
+	}
+	if reader.calls == 0 || reader.calls > 2 {
+		t.Fatal("error storage calls:", reader.calls)
+	}
+
+	// 链重组后Upgraded事件被回滚
+	p.rewind(15)
+	if impl, _ := p.lookup(proxy, 30, 0); impl != reader.impl {
+		t.Fatal("hope old implementation after rewind:", impl.Hex())
+	}
+	p.resolve(upgraded)
+	upgraded.Removed = true
+	p.resolve(upgraded)
+	if impl, _ := p.lookup(proxy, 30, 0); impl != reader.impl {
+		t.Fatal("hope old implementation after removed log:", impl.Hex())
+	}
+}
@@ -0,0 +1,44 @@
+package contractevent
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	IngestLogs     = "logs"
+	IngestReceipts = "receipts"
+)
+
+type receiptSource interface {
+	BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error)
+}
+
+// receiptLogs 通过eth_getBlockReceipts逐个区块获取收据，在本地按query的合约地址和topics过滤日志，
+// 适用于订阅大量合约或者所有合约的指定事件，eth_getLogs较慢或者被节点拒绝的场景
+func (e *Event) receiptLogs(start, end uint64) ([]types.Log, error) {
+	source, ok := e.client.(receiptSource)
+	if !ok {
+		return nil, errNotSupported
+	}
+	query := e.Query()
+	var out []types.Log
+	for n := start; n <= end; n++ {
+		receipts, err := source.BlockReceipts(context.Background(), rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(n)))
+		if err != nil {
+			log.Errorln("fail to get block receipts:", e.conf.Alias, n, err)
+			return nil, err
+		}
+		for _, r := range receipts {
+			for _, it := range r.Logs {
+				if matchQuery(query, *it) {
+					out = append(out, *it)
+				}
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,128 @@
+package contractevent
+
+import (
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultReorgDepth = 64
+	notifyReorgRetry  = 3
+)
+
+// checkReorg 检查上次处理的最后一个区块是否仍在主链上，
+// 如果hash不一致，说明发生了链重组，删除受影响的事件并回滚区块记录
+func (m *Manager) checkReorg(alias string, event *Event, c *chain, bn uint64, hash string) (bool, error) {
+	if hash == "" {
+		return false, nil
+	}
+	cur, err := c.BlockHash(bn)
+	if err != nil {
+		log.Warnln("fail to get block hash:", alias, bn, err)
+		return false, err
+	}
+	if cur.Hex() == hash {
+		return false, nil
+	}
+	log.Warnln("chain reorg detected:", alias, bn, hash, cur.Hex())
+
+	depth := c.reorgDepth()
+	var target uint64
+	if bn > depth {
+		target = bn - depth
+	}
+	if target < event.conf.StartBlock {
+		target = event.conf.StartBlock
+	}
+	removed, err := m.removeOrphans(alias, c, target)
+	if err != nil {
+		log.Errorln("fail to remove orphan events:", alias, target, err)
+		return true, err
+	}
+	tHash, err := c.BlockHash(target)
+	if err != nil {
+		return true, err
+	}
+	err = ResetBlockRecord(m.db, alias, target, tHash.Hex())
+	if err != nil {
+		log.Errorln("fail to reset block record:", alias, target, err)
+		return true, err
+	}
+	if event.proxy != nil {
+		event.proxy.rewind(target)
+	}
+	log.Warnln("rollback block record:", alias, bn, target, len(removed))
+	m.notifyReorg(alias, target+1, bn, removed)
+	return true, nil
+}
+
+// notifyReorg 通知webhook链发生了重组，事件已经删除，失败时重试几次
+func (m *Manager) notifyReorg(alias string, from, to uint64, removed []map[string]interface{}) {
+	ntf, ok := m.notification[alias]
+	if !ok {
+		return
+	}
+	wait := time.Second
+	for i := 0; i < notifyReorgRetry; i++ {
+		err := ntf.NotifyReorg(from, to, removed)
+		if err == nil {
+			return
+		}
+		log.Warnln("fail to notify reorg, retry later:", alias, from, to, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+	log.Errorln("fail to notify reorg:", alias, from, to, len(removed))
+}
+
+// removeOrphans 从最新的事件开始往前检查，删除区块高度大于after且区块hash不在主链上的事件
+func (m *Manager) removeOrphans(alias string, c *chain, after uint64) ([]map[string]interface{}, error) {
+	var removed []map[string]interface{}
+	hashes := make(map[uint64]string)
+	var before uint
+	for {
+		items, err := ListItemsBefore(m.db, alias, before, 100)
+		if err != nil || len(items) == 0 {
+			return removed, err
+		}
+		for _, it := range items {
+			before = it.ID
+			info := make(map[string]interface{})
+			json.Unmarshal(it.Others, &info)
+			num, _ := info[KBlockNumber].(float64)
+			bn := uint64(num)
+			if bn <= after {
+				return removed, nil
+			}
+			hash, ok := hashes[bn]
+			if !ok {
+				h, err := c.BlockHash(bn)
+				if err != nil {
+					return removed, err
+				}
+				hash = h.Hex()
+				hashes[bn] = hash
+			}
+			if info[KBlock] == hash {
+				continue
+			}
+			err = RemoveItem(m.db, alias, it.ID)
+			if err != nil {
+				return removed, err
+			}
+			log.Infoln("remove orphan event:", alias, it.ID, it.TX, it.LogIndex)
+			info["local_id"] = it.ID
+			removed = append(removed, info)
+		}
+	}
+}
+
+// reorgDepth 链重组可能回滚的区块数
+func (c *chain) reorgDepth() uint64 {
+	if c.conf.ReorgDepth == 0 {
+		return defaultReorgDepth
+	}
+	return c.conf.ReorgDepth
+}
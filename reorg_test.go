@@ -0,0 +1,48 @@
+package contractevent
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckReorg(t *testing.T) {
+	source := loadTestSource(t)
+	conf := Config{
+		Chain: ChainConfig{ReorgDepth: 1},
+		DB:    DBConf{Engine: "sqlite", DSN: filepath.Join(t.TempDir(), "reorg.db")},
+		Subs:  []SubscriptionConf{{Alias: "token", ABIFile: ABIERC20, EventNames: []string{"Transfer", "Approval"}}},
+	}
+	m, err := NewManagerWithSources(conf, map[string]LogSource{"": source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := m.chains[""]
+	key := conf.Subs[0].Key()
+	event := m.events[key]
+	err = event.Run(100, 102)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := c.BlockHash(102)
+	SetBlockRecordWithHash(m.db, key, 102, hash.Hex())
+	reorg, err := m.checkReorg(key, event, c, 102, hash.Hex())
+	if err != nil || reorg {
+		t.Fatal("hope no reorg:", reorg, err)
+	}
+
+	// 区块102被替换，其中的2个事件需要删除，区块记录回滚到101
+	source.AddBlock(102, 1700000030)
+	reorg, err = m.checkReorg(key, event, c, 102, hash.Hex())
+	if err != nil || !reorg {
+		t.Fatal("hope reorg:", reorg, err)
+	}
+	items, _ := ListItemsBefore(m.db, key, 0, 10)
+	if len(items) != 1 || items[0].TX != "0x0000000000000000000000000000000000000000000000000000000000000a01" {
+		t.Fatal("error items after reorg:", items)
+	}
+	bn, bHash, _ := GetBlockRecordWithHash(m.db, key)
+	hash, _ = c.BlockHash(101)
+	if bn != 101 || bHash != hash.Hex() {
+		t.Fatal("error block record:", bn, bHash)
+	}
+}
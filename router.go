@@ -10,27 +10,50 @@ import (
 )
 
 type ginRouter struct {
-	db *gorm.DB
+	db     *gorm.DB
+	chains map[string]*chain
 }
 
 func HttpRouter(router *gin.RouterGroup, db *gorm.DB) {
-	lr := ginRouter{db}
+	lr := ginRouter{db: db}
+	lr.register(router)
+}
+
+func (m *Manager) httpRouter(router *gin.RouterGroup) {
+	lr := ginRouter{db: m.db, chains: m.chains}
+	lr.register(router)
+}
+
+func (lr *ginRouter) register(router *gin.RouterGroup) {
 	router.GET("/logs", lr.getEvent)
 	router.GET("/unnotified_logs", lr.requestUnnotifiedEvent)
+	router.GET("/endpoints", lr.getEndpoints)
+}
+
+func (lr *ginRouter) chainID(name string) uint64 {
+	if c, ok := lr.chains[name]; ok {
+		return c.chainID
+	}
+	return 0
 }
 
 type reqLogParam struct {
-	Alias  string `form:"alias,omitempty"`
-	Offset int    `form:"offset,omitempty"`
-	Limit  int    `form:"limit,omitempty"`
+	Chain    string `form:"chain,omitempty"`
+	Alias    string `form:"alias,omitempty"`
+	Offset   int    `form:"offset,omitempty"`
+	Limit    int    `form:"limit,omitempty"`
+	FromTime uint64 `form:"from_time,omitempty"`
+	ToTime   uint64 `form:"to_time,omitempty"`
 }
 
 type RespItems struct {
-	Alias  string                   `json:"alias,omitempty"`
-	Offset int                      `json:"offset,omitempty"`
-	Limit  int                      `form:"limit,omitempty"`
-	Total  uint                     `json:"total,omitempty"`
-	Items  []map[string]interface{} `json:"items,omitempty"`
+	Chain   string                   `json:"chain,omitempty"`
+	ChainID uint64                   `json:"chain_id,omitempty"`
+	Alias   string                   `json:"alias,omitempty"`
+	Offset  int                      `json:"offset,omitempty"`
+	Limit   int                      `form:"limit,omitempty"`
+	Total   uint                     `json:"total,omitempty"`
+	Items   []map[string]interface{} `json:"items,omitempty"`
 }
 
 func (lr *ginRouter) getEvent(c *gin.Context) {
@@ -56,12 +79,20 @@ func (lr *ginRouter) getEvent(c *gin.Context) {
 		param.Offset = 0
 	}
 
+	key := SubscriptionKey(param.Chain, param.Alias)
 	var out RespItems
+	out.Chain = param.Chain
+	out.ChainID = lr.chainID(param.Chain)
 	out.Alias = param.Alias
 	out.Offset = param.Offset
 	out.Limit = param.Limit
-	out.Total, _ = ItemsTotal(lr.db, param.Alias)
-	items, _ := ListItems(lr.db, param.Alias, param.Offset, param.Limit)
+	out.Total, _ = ItemsTotal(lr.db, key)
+	var items []DBItem
+	if param.FromTime > 0 || param.ToTime > 0 {
+		items, _ = ListItemsByTime(lr.db, key, param.FromTime, param.ToTime, uint(param.Offset), param.Limit)
+	} else {
+		items, _ = ListItems(lr.db, key, param.Offset, param.Limit)
+	}
 	for _, it := range items {
 		info := make(map[string]interface{})
 		info["local_id"] = it.ID
@@ -73,6 +104,7 @@ func (lr *ginRouter) getEvent(c *gin.Context) {
 }
 
 type reqUnnotifiedParam struct {
+	Chain string `form:"chain,omitempty"`
 	Alias string `form:"alias,omitempty"`
 	Limit int    `form:"limit,omitempty"`
 }
@@ -97,18 +129,21 @@ func (lr *ginRouter) requestUnnotifiedEvent(c *gin.Context) {
 		param.Limit = 100
 	}
 
+	key := SubscriptionKey(param.Chain, param.Alias)
 	var out RespItems
+	out.Chain = param.Chain
+	out.ChainID = lr.chainID(param.Chain)
 	out.Alias = param.Alias
 	out.Limit = param.Limit
-	out.Total, _ = ItemsTotal(lr.db, param.Alias)
-	offset, err := GetNotifyRecord(lr.db, param.Alias)
+	out.Total, _ = ItemsTotal(lr.db, key)
+	offset, err := GetNotifyRecord(lr.db, key)
 	out.Offset = int(offset)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		log.Debugln("not any record:", param.Alias, err)
 		return
 	}
-	items, _ := ListItems(lr.db, param.Alias, int(offset), param.Limit)
+	items, _ := ListItems(lr.db, key, int(offset), param.Limit)
 	last := offset
 	for _, it := range items {
 		info := make(map[string]interface{})
@@ -119,7 +154,21 @@ func (lr *ginRouter) requestUnnotifiedEvent(c *gin.Context) {
 			last = it.ID
 		}
 	}
-	SetNotifyRecord(lr.db, param.Alias, last)
+	SetNotifyRecord(lr.db, key, last)
 	c.JSON(http.StatusOK, out)
 	log.Debugln("requestUnnotifiedEvent:", param.Alias, len(items))
 }
+
+type RespEndpoints struct {
+	Chain     string           `json:"chain"`
+	ChainID   uint64           `json:"chain_id,omitempty"`
+	Endpoints []EndpointHealth `json:"endpoints,omitempty"`
+}
+
+func (lr *ginRouter) getEndpoints(c *gin.Context) {
+	var out []RespEndpoints
+	for name, it := range lr.chains {
+		out = append(out, RespEndpoints{Chain: name, ChainID: it.chainID, Endpoints: it.Health()})
+	}
+	c.JSON(http.StatusOK, out)
+}
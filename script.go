@@ -0,0 +1,157 @@
+package contractevent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	log "github.com/sirupsen/logrus"
+)
+
+// KSubIndex 脚本或插件从一个事件派生出多个事件时，派生事件的序号
+const KSubIndex = "sub_index"
+
+const defaultScriptTimeout = 1000
+
+// processor 脚本或插件处理事件，返回处理后的事件，为空表示丢弃，多个表示派生事件
+type processor interface {
+	process(info map[string]interface{}) ([]map[string]interface{}, error)
+}
+
+// scriptProcessor 使用内嵌的JS引擎执行脚本中的process(info)函数：
+// 返回undefined时使用修改后的info，返回null/false时丢弃，返回对象或者对象数组时替换为这些事件
+type scriptProcessor struct {
+	file      string
+	timeout   time.Duration
+	mu        sync.Mutex
+	vm        *goja.Runtime
+	fn        goja.Callable
+	parse     goja.Callable
+	stringify goja.Callable
+}
+
+func newScriptProcessor(file string, timeout int64) (*scriptProcessor, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = defaultScriptTimeout
+	}
+	out := scriptProcessor{file: file, timeout: time.Duration(timeout) * time.Millisecond, vm: goja.New()}
+	out.vm.Set("log", func(args ...interface{}) {
+		log.Infoln(append([]interface{}{"script:", file}, args...)...)
+	})
+	_, err = out.vm.RunScript(file, string(src))
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	out.fn, ok = goja.AssertFunction(out.vm.Get("process"))
+	if !ok {
+		return nil, fmt.Errorf("not found function process in script:%s", file)
+	}
+	jsonObj := out.vm.Get("JSON").ToObject(out.vm)
+	out.parse, _ = goja.AssertFunction(jsonObj.Get("parse"))
+	out.stringify, _ = goja.AssertFunction(jsonObj.Get("stringify"))
+	return &out, nil
+}
+
+func (s *scriptProcessor) process(info map[string]interface{}) ([]map[string]interface{}, error) {
+	data, err := json.Marshal(scriptInput(info))
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 上次调用结束时定时器可能已经触发，清除残留的中断
+	s.vm.ClearInterrupt()
+	timer := time.AfterFunc(s.timeout, func() {
+		s.vm.Interrupt(fmt.Errorf("script timeout:%s", s.timeout))
+	})
+	defer timer.Stop()
+	input, err := s.parse(goja.Undefined(), s.vm.ToValue(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.fn(goja.Undefined(), input)
+	if err != nil {
+		return nil, fmt.Errorf("script error:%s %w", s.file, err)
+	}
+	switch {
+	case goja.IsUndefined(result):
+		result = input
+	case goja.IsNull(result):
+		return nil, nil
+	}
+	if b, ok := result.Export().(bool); ok {
+		if !b {
+			return nil, nil
+		}
+		result = input
+	}
+	out, err := s.stringify(goja.Undefined(), result)
+	if err != nil {
+		return nil, err
+	}
+	return scriptOutput(info, []byte(out.String()))
+}
+
+// scriptInput 大整数转换为字符串，避免JS中精度丢失
+func scriptInput(info map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(info))
+	for k, v := range info {
+		if n, ok := v.(*big.Int); ok && n != nil {
+			v = n.String()
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// scriptOutput 解析脚本返回的一个或多个事件，没有修改的字段保留原来的值和类型，保存事件需要的字段不允许修改
+func scriptOutput(info map[string]interface{}, data []byte) ([]map[string]interface{}, error) {
+	var items []map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := d.Decode(&items); err != nil {
+			return nil, err
+		}
+	} else {
+		var item map[string]interface{}
+		if err := d.Decode(&item); err != nil {
+			return nil, fmt.Errorf("script should return object or array of objects:%w", err)
+		}
+		items = append(items, item)
+	}
+	origin := make(map[string]string, len(info))
+	for k, v := range scriptInput(info) {
+		b, _ := json.Marshal(v)
+		origin[k] = string(b)
+	}
+	for _, item := range items {
+		if item == nil {
+			return nil, fmt.Errorf("script should return object or array of objects")
+		}
+		for k, v := range item {
+			b, _ := json.Marshal(v)
+			if origin[k] == string(b) {
+				item[k] = info[k]
+			}
+		}
+		for _, k := range reservedKeys {
+			if v, ok := info[k]; ok {
+				item[k] = v
+			} else {
+				delete(item, k)
+			}
+		}
+	}
+	return items, nil
+}
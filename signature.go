@@ -0,0 +1,210 @@
+package contractevent
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// abiParam ABI JSON中的参数
+type abiParam struct {
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Indexed    bool       `json:"indexed,omitempty"`
+	Components []abiParam `json:"components,omitempty"`
+}
+
+type abiEvent struct {
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	Anonymous bool       `json:"anonymous"`
+	Inputs    []abiParam `json:"inputs"`
+}
+
+var (
+	identRegexp     = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+	arraySuffixExpr = regexp.MustCompile(`^(\[[0-9]*\])*$`)
+	// 事件参数不能使用的修饰符
+	paramModifiers = map[string]bool{"payable": true, "memory": true, "calldata": true, "storage": true}
+)
+
+// parseEventSignature 把可读的事件签名转换为ABI JSON，如：
+//
+//	event Transfer(address indexed from, address indexed to, uint256 value)
+//	event Swap((address token, uint256 amount)[] legs, uint8 indexed kind) anonymous
+//
+// event关键字、参数名称可以省略，tuple可以写为(...)或者tuple(...)
+func parseEventSignature(sig string) (json.RawMessage, error) {
+	s := strings.TrimSuffix(strings.TrimSpace(sig), ";")
+	s = strings.TrimSpace(strings.TrimPrefix(s, "event "))
+	start := strings.Index(s, "(")
+	if start <= 0 {
+		return nil, fmt.Errorf("invalid event signature:%s", sig)
+	}
+	end, err := closingParen(s, start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event signature:%s %w", sig, err)
+	}
+	out := abiEvent{Type: "event", Name: strings.TrimSpace(s[:start])}
+	if !identRegexp.MatchString(out.Name) {
+		return nil, fmt.Errorf("invalid event name:%s", sig)
+	}
+	switch rest := strings.TrimSpace(s[end+1:]); rest {
+	case "":
+	case "anonymous":
+		out.Anonymous = true
+	default:
+		return nil, fmt.Errorf("unexpected %q in event signature:%s", rest, sig)
+	}
+	out.Inputs, err = parseParams(s[start+1:end], true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event signature:%s %w", sig, err)
+	}
+	return json.Marshal(out)
+}
+
+// closingParen 返回与start位置的左括号匹配的右括号位置
+func closingParen(s string, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unbalanced parentheses")
+}
+
+// splitParams 按最外层的逗号分割参数
+func splitParams(s string) []string {
+	var out []string
+	depth, last := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				out = append(out, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(out, s[last:])
+}
+
+func parseParams(s string, topLevel bool) ([]abiParam, error) {
+	out := []abiParam{}
+	if strings.TrimSpace(s) == "" {
+		return out, nil
+	}
+	names := make(map[string]bool)
+	for _, it := range splitParams(s) {
+		p, err := parseParam(strings.TrimSpace(it), topLevel)
+		if err != nil {
+			return nil, err
+		}
+		if p.Name != "" {
+			if names[p.Name] {
+				return nil, fmt.Errorf("duplicate parameter name:%s", p.Name)
+			}
+			names[p.Name] = true
+		}
+		out = append(out, p)
+	}
+	// 没有名称的参数使用argN，不能与其他参数的名称相同
+	for i := range out {
+		if out[i].Name != "" {
+			continue
+		}
+		out[i].Name = fmt.Sprintf("arg%d", i)
+		if names[out[i].Name] {
+			return nil, fmt.Errorf("duplicate parameter name:%s", out[i].Name)
+		}
+	}
+	return out, nil
+}
+
+// parseParam 解析"类型 [indexed] [名称]"，只有事件的参数可以使用indexed
+func parseParam(s string, topLevel bool) (abiParam, error) {
+	var p abiParam
+	if s == "" {
+		return p, fmt.Errorf("empty parameter")
+	}
+	var rest string
+	if strings.HasPrefix(s, "(") || strings.HasPrefix(s, "tuple(") {
+		start := strings.Index(s, "(")
+		end, err := closingParen(s, start)
+		if err != nil {
+			return p, err
+		}
+		p.Components, err = parseParams(s[start+1:end], false)
+		if err != nil {
+			return p, err
+		}
+		rest = s[end+1:]
+		suffix := rest
+		if i := strings.IndexAny(rest, " \t"); i >= 0 {
+			suffix, rest = rest[:i], rest[i:]
+		} else {
+			rest = ""
+		}
+		if !arraySuffixExpr.MatchString(suffix) {
+			return p, fmt.Errorf("invalid tuple type:%s", s)
+		}
+		p.Type = "tuple" + suffix
+	} else {
+		fields := strings.Fields(s)
+		p.Type = normalizeType(fields[0])
+		rest = strings.Join(fields[1:], " ")
+	}
+	fields := strings.Fields(rest)
+	for _, it := range fields {
+		if paramModifiers[it] {
+			return p, fmt.Errorf("%s is not allowed for event parameters:%s", it, s)
+		}
+	}
+	if len(fields) > 0 && fields[0] == "indexed" {
+		if !topLevel {
+			return p, fmt.Errorf("indexed is only allowed for event parameters:%s", s)
+		}
+		p.Indexed = true
+		fields = fields[1:]
+	}
+	switch len(fields) {
+	case 0:
+	case 1:
+		if !identRegexp.MatchString(fields[0]) {
+			return p, fmt.Errorf("invalid parameter name:%s", s)
+		}
+		p.Name = fields[0]
+	default:
+		return p, fmt.Errorf("invalid parameter:%s", s)
+	}
+	return p, nil
+}
+
+// normalizeType uint/int是uint256/int256的别名，byte是bytes1的别名
+func normalizeType(t string) string {
+	base, suffix := t, ""
+	if i := strings.Index(t, "["); i >= 0 {
+		base, suffix = t[:i], t[i:]
+	}
+	switch base {
+	case "uint":
+		base = "uint256"
+	case "int":
+		base = "int256"
+	case "byte":
+		base = "bytes1"
+	}
+	return base + suffix
+}
@@ -0,0 +1,78 @@
+package contractevent
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestEventSignature(t *testing.T) {
+	data, err := mergeABI(nil, []string{
+		"event Transfer(address indexed from, address indexed to, uint256 value)",
+		"Swap((address token, uint amount)[] legs, uint8 indexed kind, bytes32[2] ids);",
+		"event Note(tuple(address,int)[] indexed items, string) anonymous",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cAbi, err := parseABI(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer := cAbi.Events["Transfer"]
+	if transfer.ID.Hex() != "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef" {
+		t.Fatal("error id of Transfer:", transfer.ID.Hex())
+	}
+	if !transfer.Inputs[0].Indexed || !transfer.Inputs[1].Indexed || transfer.Inputs[2].Indexed {
+		t.Fatal("error indexed of Transfer")
+	}
+	swap := cAbi.Events["Swap"]
+	if swap.ID != crypto.Keccak256Hash([]byte("Swap((address,uint256)[],uint8,bytes32[2])")) {
+		t.Fatal("error id of Swap:", swap.Sig)
+	}
+	if swap.Inputs[0].Name != "legs" || !swap.Inputs[1].Indexed || swap.Inputs[2].Type.Size != 2 {
+		t.Fatal("error inputs of Swap:", swap.Inputs)
+	}
+	note := cAbi.Events["Note"]
+	if !note.Anonymous || note.Sig != "Note((address,int256)[],string)" || note.Inputs[1].Name != "arg1" {
+		t.Fatal("error Note:", note.Sig, note.Anonymous, note.Inputs)
+	}
+
+	for _, it := range []string{
+		"event Transfer(address indexed from",
+		"event (uint256 value)",
+		"event Foo(uint256 value) indexed",
+		"event Foo((uint256 indexed a) b)",
+		"event Foo(address from to)",
+		"event Foo(unknown a)",
+		"event Foo(address a, uint256 a)",
+		"event Foo(address, uint256 arg0)",
+		"event Foo(address payable to)",
+		"event Foo(string memory name)",
+	} {
+		data, err := mergeABI(nil, []string{it})
+		if err == nil {
+			_, err = parseABI(data)
+		}
+		if err == nil {
+			t.Error("hope error:", it)
+		}
+	}
+
+	source := loadTestSource(t)
+	sub := SubscriptionConf{
+		Alias:      "token",
+		EventName:  "Transfer",
+		Signatures: []string{"event Transfer(address indexed src, address indexed dst, uint256 wad)"},
+	}
+	var events []map[string]interface{}
+	event := newTestEvent(t, sub, source, &events)
+	event.Run(100, 102)
+	if len(events) != 2 || events[0]["src"] == nil {
+		t.Fatal("error events:", events)
+	}
+	if v, _ := events[0]["wad"].(*big.Int); v == nil || v.Int64() != 1000 {
+		t.Fatal("error wad:", events[0]["wad"])
+	}
+}
@@ -0,0 +1,243 @@
+package contractevent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// LogSource 事件日志的数据来源，Event和Manager只依赖该接口，
+// *ethclient.Client实现了该接口，测试时可以使用MemorySource
+type LogSource interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+	SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error)
+}
+
+// rpcSource 基于JSON-RPC的数据来源，可以调用LogSource以外的方法
+type rpcSource interface {
+	Client() *rpc.Client
+}
+
+var errNotSupported = errors.New("not supported by the log source")
+
+// MemorySource 内存中的数据来源，数据来自fixture文件或者手动添加，用于单元测试
+type MemorySource struct {
+	mu      sync.Mutex
+	chainID uint64
+	headers map[uint64]*types.Header
+	hashes  map[common.Hash]uint64
+	head    uint64
+	logs    []types.Log
+	feed    event.Feed
+}
+
+type memoryFixture struct {
+	ChainID uint64 `json:"chain_id"`
+	Blocks  []struct {
+		Number uint64 `json:"number"`
+		Time   uint64 `json:"time"`
+	} `json:"blocks"`
+	Logs []types.Log `json:"logs"`
+}
+
+func NewMemorySource(chainID uint64) *MemorySource {
+	return &MemorySource{
+		chainID: chainID,
+		headers: make(map[uint64]*types.Header),
+		hashes:  make(map[common.Hash]uint64),
+	}
+}
+
+// LoadMemorySource 从fixture文件加载，格式为{"chain_id":1,"blocks":[{"number":1,"time":0}],"logs":[...]}，
+// logs的格式与eth_getLogs的返回相同，blockHash为空时使用对应区块的hash
+func LoadMemorySource(file string) (*MemorySource, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var fixture memoryFixture
+	err = json.Unmarshal(data, &fixture)
+	if err != nil {
+		return nil, err
+	}
+	out := NewMemorySource(fixture.ChainID)
+	for _, it := range fixture.Blocks {
+		out.AddBlock(it.Number, it.Time)
+	}
+	out.AddLogs(fixture.Logs...)
+	return out, nil
+}
+
+// AddBlock 添加区块，最大的区块高度作为最新区块
+func (s *MemorySource) AddBlock(number, time uint64) *types.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	header := &types.Header{Number: newBig(number), Time: time, Difficulty: new(big.Int)}
+	if parent, ok := s.headers[number-1]; ok && number > 0 {
+		header.ParentHash = parent.Hash()
+	}
+	if old, ok := s.headers[number]; ok {
+		delete(s.hashes, old.Hash())
+	}
+	s.headers[number] = header
+	s.hashes[header.Hash()] = number
+	if number > s.head {
+		s.head = number
+	}
+	return header
+}
+
+// AddLogs 添加日志并推送给订阅者
+func (s *MemorySource) AddLogs(logs ...types.Log) {
+	s.mu.Lock()
+	for i, it := range logs {
+		if header, ok := s.headers[it.BlockNumber]; ok && it.BlockHash == (common.Hash{}) {
+			logs[i].BlockHash = header.Hash()
+		}
+	}
+	s.logs = append(s.logs, logs...)
+	s.mu.Unlock()
+	for _, it := range logs {
+		s.feed.Send(it)
+	}
+}
+
+func (s *MemorySource) ChainID(ctx context.Context) (*big.Int, error) {
+	return newBig(s.chainID), nil
+}
+
+func (s *MemorySource) BlockNumber(ctx context.Context) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.head, nil
+}
+
+func (s *MemorySource) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.head
+	// 最新、safe、finalized等标签都返回最新区块
+	if number != nil && number.Sign() >= 0 {
+		n = number.Uint64()
+	}
+	header, ok := s.headers[n]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return header, nil
+}
+
+func (s *MemorySource) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.hashes[hash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return s.headers[n], nil
+}
+
+func (s *MemorySource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []types.Log
+	for _, it := range s.logs {
+		if q.FromBlock != nil && it.BlockNumber < q.FromBlock.Uint64() {
+			continue
+		}
+		if q.ToBlock != nil && it.BlockNumber > q.ToBlock.Uint64() {
+			continue
+		}
+		if matchQuery(q, it) {
+			out = append(out, it)
+		}
+	}
+	return out, nil
+}
+
+// BlockReceipts 按交易把区块中的日志组合成收据
+func (s *MemorySource) BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	number, ok := blockNrOrHash.Number()
+	if !ok {
+		hash, _ := blockNrOrHash.Hash()
+		n, ok := s.hashes[hash]
+		if !ok {
+			return nil, ethereum.NotFound
+		}
+		number = rpc.BlockNumber(n)
+	}
+	var out []*types.Receipt
+	receipts := make(map[common.Hash]*types.Receipt)
+	for _, it := range s.logs {
+		if it.BlockNumber != uint64(number.Int64()) {
+			continue
+		}
+		r, ok := receipts[it.TxHash]
+		if !ok {
+			r = &types.Receipt{TxHash: it.TxHash, BlockHash: it.BlockHash, BlockNumber: newBig(it.BlockNumber),
+				TransactionIndex: it.TxIndex, Status: types.ReceiptStatusSuccessful}
+			receipts[it.TxHash] = r
+			out = append(out, r)
+		}
+		vLog := it
+		r.Logs = append(r.Logs, &vLog)
+	}
+	return out, nil
+}
+
+func (s *MemorySource) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	logs := make(chan types.Log)
+	sub := s.feed.Subscribe(logs)
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case it := <-logs:
+				if !matchQuery(q, it) {
+					continue
+				}
+				select {
+				case ch <- it:
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
+// matchQuery 判断日志是否符合query的合约地址和topics，不检查区块范围
+func matchQuery(q ethereum.FilterQuery, vLog types.Log) bool {
+	if len(q.Addresses) > 0 && !slices.Contains(q.Addresses, vLog.Address) {
+		return false
+	}
+	if len(vLog.Topics) < len(q.Topics) {
+		return false
+	}
+	for i, sub := range q.Topics {
+		if len(sub) > 0 && !slices.Contains(sub, vLog.Topics[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package contractevent
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestMemorySource(t *testing.T) {
+	source := loadTestSource(t)
+	sub := SubscriptionConf{
+		Alias:     "token",
+		Contract:  []string{"0x2260fac5e5542a773aa44fbcfedf7c193bc2c599"},
+		ABIFile:   ABIERC20,
+		EventName: "Transfer",
+		Filter:    map[string]string{"to": "0x1111111111111111111111111111111111111111"},
+	}
+	var events []map[string]interface{}
+	event := newTestEvent(t, sub, source, &events)
+	err := event.Run(100, 102)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatal("error events,hope:2,get:", len(events))
+	}
+	info := events[0]
+	if info[KChainID] != uint64(1) || info[KBlockTime] != uint64(1700000012) {
+		t.Fatal("error base info:", info)
+	}
+	if v, _ := info["value"].(*big.Int); v == nil || v.Int64() != 1000 {
+		t.Fatal("error value:", info["value"])
+	}
+
+	events = nil
+	sub.Filter = map[string]string{"from": "0x2222222222222222222222222222222222222222"}
+	event = newTestEvent(t, sub, source, &events)
+	event.Run(100, 102)
+	if len(events) != 1 || events[0][KBlockNumber] != uint64(102) {
+		t.Fatal("error filter events:", events)
+	}
+
+	events = nil
+	sub.Ingest = IngestReceipts
+	event = newTestEvent(t, sub, source, &events)
+	event.Run(100, 102)
+	if len(events) != 1 || events[0][KBlockNumber] != uint64(102) {
+		t.Fatal("error events from receipts:", events)
+	}
+}
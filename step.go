@@ -0,0 +1,86 @@
+package contractevent
+
+import (
+	"strings"
+)
+
+// 节点对eth_getLogs的常见限制错误
+var rangeErrors = []string{
+	"more than 10000 results",
+	"query returned more than",
+	"block range",
+	"range too large",
+	"too many results",
+	"response size exceeded",
+	"query timeout exceeded",
+	"log response size",
+}
+
+func isRangeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, it := range rangeErrors {
+		if strings.Contains(msg, it) {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回的日志数量低于该值时认为请求足够小，连续多次后扩大区间
+const (
+	smallResponse    = 1000
+	growAfterSuccess = 3
+)
+
+// blockStep 自适应的区块区间，size为每次请求的区块数
+type blockStep struct {
+	size    uint64
+	max     uint64
+	success int
+}
+
+func newBlockStep(max, learned uint64) *blockStep {
+	// BlocksPerReq为0时每次只请求1个区块
+	max++
+	size := max
+	if learned > 0 && learned < max {
+		size = learned
+	}
+	return &blockStep{size: size, max: max}
+}
+
+// Last 返回从start开始的区间结束高度
+func (s *blockStep) Last(start uint64) uint64 {
+	return start + s.size - 1
+}
+
+// Shrink 区间减半，已经是1个区块时返回false
+func (s *blockStep) Shrink() bool {
+	s.success = 0
+	if s.size <= 1 {
+		return false
+	}
+	s.size /= 2
+	return true
+}
+
+// Grow 请求成功后调用，连续多次小响应后区间加倍，返回区间是否变化
+func (s *blockStep) Grow(logs int) bool {
+	if s.size >= s.max || logs >= smallResponse {
+		s.success = 0
+		return false
+	}
+	s.success++
+	if s.success < growAfterSuccess {
+		return false
+	}
+	s.success = 0
+	s.size *= 2
+	if s.size > s.max {
+		s.size = s.max
+	}
+	return true
+}
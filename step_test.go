@@ -0,0 +1,43 @@
+package contractevent
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBlockStep(t *testing.T) {
+	s := newBlockStep(99, 0)
+	if s.Last(1) != 100 {
+		t.Fatal("error last,hope:100,get:", s.Last(1))
+	}
+	if !isRangeError(errors.New("query returned more than 10000 results")) {
+		t.Fatal("hope range error")
+	}
+	// 区间过大不是节点的问题，不切换节点
+	if isEndpointError(errors.New("query returned more than 10000 results")) {
+		t.Fatal("range error is not endpoint error")
+	}
+	for i := 0; i < 10; i++ {
+		s.Shrink()
+	}
+	if s.size != 1 || s.Shrink() {
+		t.Fatal("error size,hope:1,get:", s.size)
+	}
+	for i := 0; i < growAfterSuccess; i++ {
+		s.Grow(smallResponse)
+	}
+	if s.size != 1 {
+		t.Fatal("big response should not grow:", s.size)
+	}
+	for i := 0; i < growAfterSuccess*20; i++ {
+		s.Grow(1)
+	}
+	if s.size != 100 {
+		t.Fatal("error size,hope:100,get:", s.size)
+	}
+
+	s = newBlockStep(99, 25)
+	if s.Last(1) != 25 {
+		t.Fatal("error learned step,hope:25,get:", s.Last(1))
+	}
+}
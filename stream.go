@@ -0,0 +1,157 @@
+package contractevent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+)
+
+var errStopped = errors.New("stopped")
+
+// runStream 实时订阅模式，异常退出后会重新订阅
+func (m *Manager) runStream(alias string, event *Event, c *chain) {
+	defer m.wg.Done()
+	for {
+		err := m.stream(alias, event, c)
+		if errors.Is(err, errStopped) {
+			return
+		}
+		log.Warnln("stream interrupted, resubscribe later:", alias, err)
+		select {
+		case <-time.After(5 * time.Second):
+		case <-m.stopping:
+			return
+		}
+	}
+}
+
+// stream 先订阅，再用区间查询补齐BlockRecord到当前最新区块之间的事件，
+// 补齐期间收到的日志缓存在channel中，之后跳过补齐时已处理的区块（按区块hash比较），保证不丢失也不重复
+func (m *Manager) stream(alias string, event *Event, c *chain) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan types.Log, 1024)
+	sub, err := event.Subscribe(ctx, ch)
+	if err != nil {
+		log.Errorln("fail to subscribe logs:", alias, err)
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	// 使用订阅节点的高度，其他节点可能落后或者领先于订阅节点
+	head, err := c.subscriber.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	bn, err := GetBlockRecord(m.db, alias)
+	if err != nil {
+		return err
+	}
+	// 补齐时处理过的区块hash，订阅收到的相同区块的日志已经处理过，
+	// 区块hash不同时说明该区块被重组替换（旧区块可能没有日志，节点不会推送Removed），需要处理
+	done := make(map[uint64]common.Hash)
+	for bn < head {
+		select {
+		case <-m.stopping:
+			return errStopped
+		default:
+		}
+		end := bn + 1 + event.conf.BlocksPerReq
+		if end > head {
+			end = head
+		}
+		logs, err := event.fetchLogs(bn+1, end)
+		if err != nil {
+			log.Errorln("fail to FilterLogs:", alias, err)
+			return err
+		}
+		err = event.handle(logs)
+		if err != nil {
+			return err
+		}
+		for _, it := range logs {
+			// 订阅只会推送最新的区块，只需要记录可能被重组的区块
+			if it.BlockNumber+c.reorgDepth() >= head {
+				done[it.BlockNumber] = it.BlockHash
+			}
+		}
+		hash, err := c.BlockHash(end)
+		if err != nil {
+			return err
+		}
+		err = SetBlockRecordWithHash(m.db, alias, end, hash.Hex())
+		if err != nil {
+			return err
+		}
+		log.Infoln("finish block:", alias, bn+1, end)
+		bn = end
+	}
+	log.Infoln("switch to live stream:", alias, bn)
+
+	// 最后收到日志的区块，收到之后区块的日志或者一段时间没有新日志时认为已经处理完
+	var last types.Log
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stopping:
+			return errStopped
+		case err := <-sub.Err():
+			return err
+		case <-ticker.C:
+			if last.BlockNumber == 0 || len(ch) > 0 {
+				continue
+			}
+			// 订阅节点的高度超过该区块时，该区块的日志已经全部收到
+			head, err := c.subscriber.BlockNumber(ctx)
+			if err != nil {
+				return err
+			}
+			if head <= last.BlockNumber {
+				continue
+			}
+			err = SetBlockRecordWithHash(m.db, alias, last.BlockNumber, last.BlockHash.Hex())
+			if err != nil {
+				return err
+			}
+			last = types.Log{}
+		case vLog := <-ch:
+			if !vLog.Removed && vLog.BlockNumber <= bn && done[vLog.BlockNumber] == vLog.BlockHash {
+				continue
+			}
+			info, err := event.process(vLog)
+			if err != nil {
+				return err
+			}
+			if vLog.Removed {
+				log.Warnln("removed log:", alias, vLog.BlockNumber, vLog.TxHash.Hex(), vLog.Index)
+				if info != nil {
+					m.notifyReorg(alias, vLog.BlockNumber, vLog.BlockNumber, []map[string]interface{}{info})
+				}
+				if vLog.BlockHash == last.BlockHash {
+					last = types.Log{}
+				}
+				// 已经记录的区块被回滚时，回退BlockRecord，新区块的日志会重新记录
+				if rec, _ := GetBlockRecord(m.db, alias); rec >= vLog.BlockNumber && vLog.BlockNumber > 0 {
+					ResetBlockRecord(m.db, alias, vLog.BlockNumber-1, "")
+				}
+				if bn >= vLog.BlockNumber && vLog.BlockNumber > 0 {
+					bn = vLog.BlockNumber - 1
+				}
+				continue
+			}
+			// 同一区块可能还有后续日志，收到下一个区块的日志后才记录
+			if last.BlockNumber > 0 && vLog.BlockNumber > last.BlockNumber {
+				err = SetBlockRecordWithHash(m.db, alias, last.BlockNumber, last.BlockHash.Hex())
+				if err != nil {
+					return err
+				}
+			}
+			last = vLog
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package contractevent
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// hookSource 第一次区间查询之后执行hook，模拟补齐期间订阅收到的日志
+type hookSource struct {
+	*MemorySource
+	once sync.Once
+	hook func()
+}
+
+func (s *hookSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	logs, err := s.MemorySource.FilterLogs(ctx, q)
+	s.once.Do(s.hook)
+	return logs, err
+}
+
+func TestStreamHandoff(t *testing.T) {
+	source := loadTestSource(t)
+	source.AddBlock(103, 1700000036)
+	backfilled, _ := source.FilterLogs(context.Background(), ethereum.FilterQuery{})
+	replaced := backfilled[0]
+	replaced.BlockNumber = 103
+	replaced.BlockHash = common.Hash{}
+	replaced.TxHash = common.HexToHash("0xb03")
+	hs := &hookSource{MemorySource: source}
+	hs.hook = func() {
+		// 补齐时已经处理的日志，不能重复处理
+		source.feed.Send(backfilled[0])
+		// 补齐的区块103没有日志，之后被有日志的区块替换，节点不会推送Removed
+		source.AddBlock(103, 1700000037)
+		source.AddLogs(replaced)
+	}
+	conf := Config{
+		DB: DBConf{Engine: "sqlite", DSN: filepath.Join(t.TempDir(), "stream.db")},
+		Subs: []SubscriptionConf{{Alias: "token", ABIFile: ABIERC20, EventNames: []string{"Transfer", "Approval"},
+			Mode: ModeStream, StartBlock: 99, BlocksPerReq: 10}},
+	}
+	m, err := NewManagerWithSources(conf, map[string]LogSource{"": hs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := conf.Subs[0].Key()
+	event := m.events[key]
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	event.hooks = append(event.hooks, func(alias string, info map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if removed, _ := info[KRemoved].(bool); !removed {
+			counts[info[KTX].(string)]++
+		}
+		return nil
+	})
+	done := make(chan error)
+	go func() {
+		done <- m.stream(key, event, m.chains[""])
+	}()
+	wait := func(hope int) []DBItem {
+		var items []DBItem
+		for i := 0; i < 100; i++ {
+			items, _ = ListItems(m.db, key, 0, 10)
+			if len(items) == hope {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return items
+	}
+	items := wait(4)
+	if len(items) != 4 || items[3].TX != replaced.TxHash.Hex() {
+		t.Fatal("error items after handoff:", items)
+	}
+
+	// 被移除的日志需要删除，区块记录不能超过被移除的区块
+	SetBlockRecord(m.db, key, 103)
+	removed := replaced
+	header, _ := source.HeaderByNumber(context.Background(), newBig(103))
+	removed.BlockHash = header.Hash()
+	removed.Removed = true
+	source.feed.Send(removed)
+	items = wait(3)
+	if len(items) != 3 {
+		t.Fatal("error items after removed:", items)
+	}
+	bn, _ := GetBlockRecord(m.db, key)
+	if bn != 102 {
+		t.Fatal("error block record after removed:", bn)
+	}
+
+	// 实时的新区块
+	source.AddBlock(104, 1700000048)
+	live := replaced
+	live.BlockNumber = 104
+	live.TxHash = common.HexToHash("0xb04")
+	source.AddLogs(live)
+	items = wait(4)
+	if len(items) != 4 || items[3].TX != live.TxHash.Hex() {
+		t.Fatal("error live items:", items)
+	}
+	m.stopping <- 1
+	if err = <-done; err != errStopped {
+		t.Fatal("hope stopped:", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for tx, n := range counts {
+		if n != 1 {
+			t.Error("duplicate event:", tx, n)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// 大额转账拆分为转出和转入两个事件，小额转账丢弃
+function process(info) {
+  if (info.event_name !== "Transfer") {
+    return;
+  }
+  if (Number(info.value) < 2000) {
+    return null;
+  }
+  return [
+    Object.assign({}, info, {direction: "out", account: info.from}),
+    Object.assign({}, info, {direction: "in", account: info.to}),
+  ];
+}
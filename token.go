@@ -0,0 +1,163 @@
+package contractevent
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	KTokenSymbol   = "token_symbol"
+	KTokenDecimals = "token_decimals"
+	KTokenAmount   = "token_amount"
+)
+
+type contractCaller interface {
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// tokenMeta 通过eth_call获取代币的decimals和symbol，每个合约只查询一次，
+// 结果保存在内存和TokenRecord表中
+type tokenMeta struct {
+	caller  contractCaller
+	erc20   abi.ABI
+	field   string
+	chainID uint64
+	db      *gorm.DB
+	mu      sync.Mutex
+	cache   map[common.Address]*TokenRecord
+}
+
+func newTokenMeta(conf SubscriptionConf, client LogSource) (*tokenMeta, error) {
+	caller, ok := client.(contractCaller)
+	if !ok {
+		return nil, errNotSupported
+	}
+	erc20, err := abi.JSON(bytes.NewReader(GetABIData(ABIERC20)))
+	if err != nil {
+		return nil, err
+	}
+	out := tokenMeta{caller: caller, erc20: erc20, field: conf.AmountField, cache: make(map[common.Address]*TokenRecord)}
+	if out.field == "" {
+		out.field = "value"
+	}
+	return &out, nil
+}
+
+// annotate 增加token_symbol、token_decimals以及按decimals换算后的token_amount，合约不是代币时不处理
+func (t *tokenMeta) annotate(info map[string]interface{}, contract common.Address) error {
+	record, err := t.get(contract)
+	if err != nil {
+		return err
+	}
+	if !record.Valid {
+		return nil
+	}
+	info[KTokenSymbol] = record.Symbol
+	info[KTokenDecimals] = record.Decimals
+	if v, ok := info[t.field].(*big.Int); ok {
+		info[KTokenAmount] = formatUnits(v, record.Decimals)
+	}
+	return nil
+}
+
+func (t *tokenMeta) get(contract common.Address) (*TokenRecord, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if record, ok := t.cache[contract]; ok {
+		return record, nil
+	}
+	if t.db != nil {
+		record, err := GetTokenRecord(t.db, t.chainID, contract.Hex())
+		if err == nil {
+			t.cache[contract] = record
+			return record, nil
+		}
+	}
+	record, err := t.fetch(contract)
+	if err != nil {
+		return nil, err
+	}
+	t.cache[contract] = record
+	if t.db != nil {
+		SetTokenRecord(t.db, record)
+	}
+	log.Infoln("new token:", contract.Hex(), record.Symbol, record.Decimals, record.Valid)
+	return record, nil
+}
+
+// fetch 节点异常时返回错误，之后重试；合约执行失败或返回的数据无法解析（如EOA返回空数据）说明不是代币，记录为无效
+func (t *tokenMeta) fetch(contract common.Address) (*TokenRecord, error) {
+	record := &TokenRecord{ChainID: t.chainID, Address: contract.Hex()}
+	data, err := t.call(contract, "decimals")
+	if err != nil {
+		if isEndpointError(err) {
+			return nil, err
+		}
+		log.Debugln("not a token contract:", contract.Hex(), err)
+		return record, nil
+	}
+	out, err := t.erc20.Unpack("decimals", data)
+	if err != nil || len(out) == 0 {
+		log.Debugln("fail to unpack decimals:", contract.Hex(), err)
+		return record, nil
+	}
+	record.Decimals, _ = out[0].(uint8)
+	record.Valid = true
+	data, err = t.call(contract, "symbol")
+	if err != nil {
+		if isEndpointError(err) {
+			return nil, err
+		}
+		return record, nil
+	}
+	record.Symbol = t.unpackSymbol(data)
+	return record, nil
+}
+
+func (t *tokenMeta) call(contract common.Address, method string) ([]byte, error) {
+	input, err := t.erc20.Pack(method)
+	if err != nil {
+		return nil, err
+	}
+	return t.caller.CallContract(context.Background(), ethereum.CallMsg{To: &contract, Data: input}, nil)
+}
+
+// unpackSymbol 兼容symbol返回bytes32的代币，如MKR
+func (t *tokenMeta) unpackSymbol(data []byte) string {
+	out, err := t.erc20.Unpack("symbol", data)
+	if err == nil && len(out) > 0 {
+		if s, ok := out[0].(string); ok {
+			return s
+		}
+	}
+	if len(data) == 32 {
+		return strings.TrimRight(string(data), "\x00")
+	}
+	return ""
+}
+
+// formatUnits 按decimals把整数转换为十进制小数，如1500000(decimals为6)转换为"1.5"
+func formatUnits(v *big.Int, decimals uint8) string {
+	s := new(big.Int).Abs(v).String()
+	if decimals > 0 {
+		d := int(decimals)
+		if len(s) <= d {
+			s = strings.Repeat("0", d-len(s)+1) + s
+		}
+		s = s[:len(s)-d] + "." + s[len(s)-d:]
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if v.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
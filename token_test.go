@@ -0,0 +1,62 @@
+package contractevent
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestFormatUnits(t *testing.T) {
+	cases := []struct {
+		value    int64
+		decimals uint8
+		hope     string
+	}{
+		{1500000, 6, "1.5"},
+		{1000000, 6, "1"},
+		{1, 6, "0.000001"},
+		{0, 18, "0"},
+		{-25, 1, "-2.5"},
+		{123, 0, "123"},
+	}
+	for _, it := range cases {
+		out := formatUnits(big.NewInt(it.value), it.decimals)
+		if out != it.hope {
+			t.Error("error amount,hope:", it.hope, ",get:", out)
+		}
+	}
+}
+
+type emptyCaller struct {
+	calls int
+}
+
+func (c *emptyCaller) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	c.calls++
+	return nil, nil
+}
+
+func TestTokenMetaEmptyResult(t *testing.T) {
+	caller := &emptyCaller{}
+	erc20, err := abi.JSON(bytes.NewReader(GetABIData(ABIERC20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := &tokenMeta{caller: caller, erc20: erc20, field: "value", cache: make(map[common.Address]*TokenRecord)}
+	info := map[string]interface{}{"value": big.NewInt(100)}
+	// 没有代码的地址返回空数据，记录为无效并缓存，不再重复查询
+	for i := 0; i < 2; i++ {
+		err = meta.annotate(info, common.HexToAddress("0x01"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := info[KTokenAmount]; ok || caller.calls != 1 {
+		t.Fatal("error annotate:", info, caller.calls)
+	}
+}
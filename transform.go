@@ -0,0 +1,193 @@
+package contractevent
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ComputeScale  = "scale"
+	ComputeLower  = "lower"
+	ComputeUpper  = "upper"
+	ComputeString = "string"
+)
+
+// reservedKeys 保存和移除事件需要的字段，不允许修改
+var reservedKeys = []string{KAlias, KBlock, KTX, KLogIndex, KBlockNumber, KRemoved}
+
+type transform struct {
+	conf TransformConf
+}
+
+func newTransform(conf *TransformConf) (*transform, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	for _, it := range conf.Compute {
+		if it.Field == "" || it.From == "" {
+			return nil, fmt.Errorf("compute requires field and from")
+		}
+		switch it.Func {
+		case ComputeScale:
+			if it.Arg == "" {
+				return nil, fmt.Errorf("scale requires arg:%s", it.Field)
+			}
+		case ComputeLower, ComputeUpper, ComputeString:
+		default:
+			return nil, fmt.Errorf("unknown compute func:%s", it.Func)
+		}
+	}
+	targets := make(map[string]bool)
+	for from, to := range conf.Rename {
+		if to == "" || to == from || targets[to] {
+			return nil, fmt.Errorf("error rename target:%s %s", from, to)
+		}
+		targets[to] = true
+	}
+	for _, key := range reservedKeys {
+		if err := conf.protect(key); err != nil {
+			return nil, err
+		}
+	}
+	return &transform{conf: *conf}, nil
+}
+
+// protect 检查字段是否会被改名、删除或者覆盖
+func (c *TransformConf) protect(key string) error {
+	if c == nil {
+		return nil
+	}
+	if _, ok := c.Rename[key]; ok || c.drops(key) {
+		return fmt.Errorf("not allowed to rename or drop field:%s", key)
+	}
+	for _, to := range c.Rename {
+		if to == key {
+			return fmt.Errorf("not allowed to rename to field:%s", key)
+		}
+	}
+	if _, ok := c.Set[key]; ok {
+		return fmt.Errorf("not allowed to set field:%s", key)
+	}
+	for _, it := range c.Compute {
+		if it.Field == key {
+			return fmt.Errorf("not allowed to compute field:%s", key)
+		}
+	}
+	return nil
+}
+
+func (c *TransformConf) drops(key string) bool {
+	if c == nil {
+		return false
+	}
+	for _, it := range c.Drop {
+		if it == key {
+			return true
+		}
+	}
+	return false
+}
+
+// apply 修改info，来源字段不存在或者类型不匹配时不计算该字段
+func (t *transform) apply(info map[string]interface{}) {
+	for _, it := range t.conf.Compute {
+		v, ok := info[it.From]
+		if !ok || v == nil {
+			continue
+		}
+		out, err := compute(it, v, info)
+		if err != nil {
+			log.Debugln("fail to compute field:", it.Field, it.Func, err)
+			continue
+		}
+		info[it.Field] = out
+	}
+	for from, to := range t.conf.Rename {
+		v, ok := info[from]
+		if !ok {
+			continue
+		}
+		if _, ok := info[to]; ok {
+			log.Warnln("rename target already exists:", from, to)
+			continue
+		}
+		delete(info, from)
+		info[to] = v
+	}
+	for key, value := range t.conf.Set {
+		info[key] = value
+	}
+	for _, key := range t.conf.Drop {
+		delete(info, key)
+	}
+}
+
+func compute(conf ComputeConf, v interface{}, info map[string]interface{}) (interface{}, error) {
+	switch conf.Func {
+	case ComputeScale:
+		n, ok := toBigInt(v)
+		if !ok {
+			return nil, fmt.Errorf("not an integer:%v", v)
+		}
+		decimals, err := scaleDecimals(conf.Arg, info)
+		if err != nil {
+			return nil, err
+		}
+		return formatUnits(n, decimals), nil
+	case ComputeLower:
+		return strings.ToLower(stringValue(v)), nil
+	case ComputeUpper:
+		return strings.ToUpper(stringValue(v)), nil
+	default:
+		return stringValue(v), nil
+	}
+}
+
+// scaleDecimals arg可以是数字，也可以是字段名
+func scaleDecimals(arg string, info map[string]interface{}) (uint8, error) {
+	if d, err := strconv.ParseUint(arg, 10, 8); err == nil {
+		return uint8(d), nil
+	}
+	n, ok := toBigInt(info[arg])
+	if !ok || !n.IsUint64() || n.Uint64() > 255 {
+		return 0, fmt.Errorf("error decimals:%s", arg)
+	}
+	return uint8(n.Uint64()), nil
+}
+
+func toBigInt(v interface{}) (*big.Int, bool) {
+	switch val := v.(type) {
+	case *big.Int:
+		return val, val != nil
+	case uint8:
+		return new(big.Int).SetUint64(uint64(val)), true
+	case uint64:
+		return new(big.Int).SetUint64(val), true
+	case uint:
+		return new(big.Int).SetUint64(uint64(val)), true
+	case int:
+		return big.NewInt(int64(val)), true
+	case int64:
+		return big.NewInt(val), true
+	case string:
+		return new(big.Int).SetString(val, 10)
+	}
+	return nil, false
+}
+
+func stringValue(v interface{}) string {
+	switch val := v.(type) {
+	case common.Address:
+		return val.Hex()
+	case common.Hash:
+		return val.Hex()
+	case fmt.Stringer:
+		return val.String()
+	}
+	return fmt.Sprint(v)
+}
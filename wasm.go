@@ -0,0 +1,151 @@
+package contractevent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+const defaultPluginFunc = "process"
+
+// wasmProcessor WASM插件，使用纯Go的WASM运行时，插件没有文件和网络权限。
+// 插件需要导出memory、alloc(size i32) i32以及处理函数(ptr i32, len i32) i64，
+// 处理函数的参数为事件的JSON，返回值的高32位为结果的地址，低32位为长度，
+// 结果为null/false时丢弃事件，为true或者长度为0时不修改事件，为对象或者对象数组时替换为这些事件。
+// 如果导出了free(ptr i32, len i32)，使用完输入和结果后调用free释放
+type wasmProcessor struct {
+	file     string
+	fn       string
+	timeout  time.Duration
+	mu       sync.Mutex
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	mod      api.Module
+}
+
+func newWASMProcessor(file, fn string, timeout int64) (*wasmProcessor, error) {
+	code, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if fn == "" {
+		fn = defaultPluginFunc
+	}
+	if timeout <= 0 {
+		timeout = defaultScriptTimeout
+	}
+	out := wasmProcessor{file: file, fn: fn, timeout: time.Duration(timeout) * time.Millisecond}
+	ctx := context.Background()
+	// 超时后中断插件的执行
+	out.runtime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	_, err = wasi_snapshot_preview1.Instantiate(ctx, out.runtime)
+	if err != nil {
+		return nil, err
+	}
+	out.compiled, err = out.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	exports := out.compiled.ExportedFunctions()
+	for _, name := range []string{"alloc", fn} {
+		if _, ok := exports[name]; !ok {
+			return nil, fmt.Errorf("not found function %s in plugin:%s", name, file)
+		}
+	}
+	if _, ok := out.compiled.ExportedMemories()["memory"]; !ok {
+		return nil, fmt.Errorf("not found memory in plugin:%s", file)
+	}
+	err = out.instantiate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// instantiate 创建插件实例，插件超时或者出错（如trap）后重新创建，插件中的状态会丢失
+func (p *wasmProcessor) instantiate(ctx context.Context) error {
+	config := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	mod, err := p.runtime.InstantiateModule(ctx, p.compiled, config)
+	if err != nil {
+		return err
+	}
+	p.mod = mod
+	return nil
+}
+
+func (p *wasmProcessor) process(info map[string]interface{}) ([]map[string]interface{}, error) {
+	data, err := json.Marshal(scriptInput(info))
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	if p.mod == nil || p.mod.IsClosed() {
+		err = p.instantiate(context.Background())
+		if err != nil {
+			return nil, err
+		}
+	}
+	out, err := p.call(ctx, data)
+	if err != nil {
+		// trap之后插件的内存和全局变量可能处于不一致的状态，不再使用该实例
+		p.mod.Close(context.Background())
+		p.mod = nil
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("plugin timeout:%s %s", p.file, p.timeout)
+		}
+		return nil, fmt.Errorf("plugin error:%s %w", p.file, err)
+	}
+	out = bytes.TrimSpace(out)
+	switch string(out) {
+	case "", "true":
+		return []map[string]interface{}{info}, nil
+	case "null", "false":
+		return nil, nil
+	}
+	return scriptOutput(info, out)
+}
+
+func (p *wasmProcessor) call(ctx context.Context, data []byte) ([]byte, error) {
+	mem := p.mod.Memory()
+	res, err := p.mod.ExportedFunction("alloc").Call(ctx, uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(res[0])
+	if !mem.Write(ptr, data) {
+		return nil, fmt.Errorf("out of memory range:%d %d", ptr, len(data))
+	}
+	res, err = p.mod.ExportedFunction(p.fn).Call(ctx, uint64(ptr), uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	// 插件的内存之后可能被修改，需要复制结果
+	view, ok := mem.Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("out of memory range:%d %d", outPtr, outLen)
+	}
+	out := bytes.Clone(view)
+	if free := p.mod.ExportedFunction("free"); free != nil {
+		_, err = free.Call(ctx, uint64(ptr), uint64(len(data)))
+		if err == nil && outLen > 0 {
+			_, err = free.Call(ctx, uint64(outPtr), uint64(outLen))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fail to free:%w", err)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,121 @@
+package contractevent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPlugin 手写的WASM模块：alloc为简单的bump分配器，process原样返回输入，drop返回"null"，keep返回"true"，
+// split返回两个事件的数组，loop为死循环，trap执行unreachable
+var testPlugin = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// type: (i32)->i32, (i32,i32)->i64
+	0x01, 0x0c, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e,
+	// function: alloc, process, drop, keep, split, loop, trap
+	0x03, 0x08, 0x07, 0x00, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01,
+	// memory: 2 pages
+	0x05, 0x03, 0x01, 0x00, 0x02,
+	// global: mut i32 = 1024
+	0x06, 0x07, 0x01, 0x7f, 0x01, 0x41, 0x80, 0x08, 0x0b,
+	// export
+	0x07, 0x40, 0x08,
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	0x05, 'a', 'l', 'l', 'o', 'c', 0x00, 0x00,
+	0x07, 'p', 'r', 'o', 'c', 'e', 's', 's', 0x00, 0x01,
+	0x04, 'd', 'r', 'o', 'p', 0x00, 0x02,
+	0x04, 'k', 'e', 'e', 'p', 0x00, 0x03,
+	0x05, 's', 'p', 'l', 'i', 't', 0x00, 0x04,
+	0x04, 'l', 'o', 'o', 'p', 0x00, 0x05,
+	0x04, 't', 'r', 'a', 'p', 0x00, 0x06,
+	// code
+	0x0a, 0x40, 0x07,
+	0x0b, 0x00, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b,
+	0x0c, 0x00, 0x20, 0x00, 0xad, 0x42, 0x20, 0x86, 0x20, 0x01, 0xad, 0x84, 0x0b,
+	0x04, 0x00, 0x42, 0x04, 0x0b,
+	0x09, 0x00, 0x42, 0x84, 0x80, 0x80, 0x80, 0xc0, 0x00, 0x0b,
+	0x09, 0x00, 0x42, 0x9b, 0x80, 0x80, 0x80, 0x80, 0x01, 0x0b,
+	0x08, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x00, 0x0b,
+	0x03, 0x00, 0x00, 0x0b,
+	// data: "null" at 0, "true" at 4, split result at 8
+	0x0b, 0x29, 0x01, 0x00, 0x41, 0x00, 0x0b, 0x23,
+	'n', 'u', 'l', 'l', 't', 'r', 'u', 'e',
+	'[', '{', '"', 'p', 'a', 'r', 't', '"', ':', '"', 'a', '"', '}', ',', '{', '"', 'p', 'a', 'r', 't', '"', ':', '"', 'b', '"', '}', ']',
+}
+
+func TestWASMPlugin(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "plugin.wasm")
+	err := os.WriteFile(file, testPlugin, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := loadTestSource(t)
+	sub := SubscriptionConf{
+		Alias:     "token",
+		ABIFile:   ABIERC20,
+		EventName: "Transfer",
+		Plugin:    file,
+	}
+	var events []map[string]interface{}
+	run := func(fn string) {
+		events = nil
+		sub.PluginFunc = fn
+		event := newTestEvent(t, sub, source, &events)
+		err = event.Run(100, 102)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("")
+	if len(events) != 2 || events[0]["value"] == nil || events[1][KBlockNumber] != uint64(102) {
+		t.Fatal("error events:", events)
+	}
+	run("drop")
+	if len(events) != 0 {
+		t.Fatal("hope drop all events:", events)
+	}
+	run("keep")
+	if len(events) != 2 || events[0]["value"] == nil {
+		t.Fatal("hope keep all events:", events)
+	}
+	run("split")
+	if len(events) != 4 || events[0]["part"] != "a" || events[1]["part"] != "b" ||
+		events[0][KSubIndex] != uint(0) || events[1][KSubIndex] != uint(1) || events[1][KTX] != events[0][KTX] {
+		t.Fatal("error split events:", events)
+	}
+
+	sub.PluginFunc = "not_exist"
+	_, err = NewEvent(sub, source, func(alias string, info map[string]interface{}) error { return nil })
+	if err == nil {
+		t.Fatal("hope error of unknown function")
+	}
+}
+
+func TestWASMPluginError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "plugin.wasm")
+	err := os.WriteFile(file, testPlugin, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := map[string]interface{}{KTX: "0x01"}
+	for _, fn := range []string{"loop", "trap"} {
+		plugin, err := newWASMProcessor(file, fn, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mod := plugin.mod
+		_, err = plugin.process(info)
+		if err == nil {
+			t.Fatal("hope error of plugin:", fn)
+		}
+		// 出错后使用新的实例
+		plugin.fn = defaultPluginFunc
+		items, err := plugin.process(info)
+		if err != nil || len(items) != 1 || items[0][KTX] != "0x01" {
+			t.Fatal("error items:", fn, items, err)
+		}
+		if plugin.mod == mod {
+			t.Fatal("hope new instance:", fn)
+		}
+	}
+}